@@ -2,10 +2,14 @@ package certs
 
 import (
 	"context"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/pem"
+	"fmt"
 	"log"
 
 	"github.com/Moranilt/jwt-http2/config"
@@ -13,16 +17,18 @@ import (
 )
 
 type Certs struct {
-	private  []byte
-	public   []byte
-	vault    *vault.Client
-	vaultCfg *config.VaultEnv
+	private   []byte
+	public    []byte
+	algorithm string
+	vault     *vault.Client
+	vaultCfg  *config.VaultEnv
 }
 
-func NewKeys(v *vault.Client, env *config.VaultEnv) *Certs {
+func NewKeys(v *vault.Client, env *config.VaultEnv, algorithm string) *Certs {
 	k := new(Certs)
 	k.vault = v
 	k.vaultCfg = env
+	k.algorithm = algorithm
 	k.generateKeys()
 	return k
 }
@@ -41,6 +47,7 @@ func (k *Certs) StoreToVault() error {
 		k.vaultCfg.PublicCertPath,
 		map[string]interface{}{
 			"key": string(k.public),
+			"alg": k.algorithm,
 		},
 	)
 	if err != nil {
@@ -52,6 +59,7 @@ func (k *Certs) StoreToVault() error {
 		k.vaultCfg.PrivateCertPath,
 		map[string]interface{}{
 			"key": string(k.private),
+			"alg": k.algorithm,
 		},
 	)
 	if err != nil {
@@ -62,19 +70,71 @@ func (k *Certs) StoreToVault() error {
 }
 
 func (k *Certs) generateKeys() {
+	public, private, err := GenerateKeys(k.algorithm)
+	if err != nil {
+		log.Fatal(err)
+	}
+
+	k.public = public
+	k.private = private
+}
+
+// GenerateKeys makes new PEM encoded pair of keys for signing algorithm:
+// RSA-2048 for RS256 and PS256, P-256 for ES256 and Ed25519 for EdDSA.
+func GenerateKeys(algorithm string) (public []byte, private []byte, err error) {
 	reader := rand.Reader
-	bitSize := 2048
 
-	key, err := rsa.GenerateKey(reader, bitSize)
+	switch algorithm {
+	case config.ALGORITHM_RS256, config.ALGORITHM_PS256:
+		bitSize := 2048
+		key, err := rsa.GenerateKey(reader, bitSize)
+		if err != nil {
+			return nil, nil, err
+		}
+		public, err = makePublicPEMKey(&key.PublicKey)
+		if err != nil {
+			return nil, nil, err
+		}
+		return public, makePrivatePEMKey(key), nil
+
+	case config.ALGORITHM_ES256:
+		key, err := ecdsa.GenerateKey(elliptic.P256(), reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return makePEMKeys(key.Public(), key)
+
+	case config.ALGORITHM_EdDSA:
+		pub, key, err := ed25519.GenerateKey(reader)
+		if err != nil {
+			return nil, nil, err
+		}
+		return makePEMKeys(pub, key)
+
+	default:
+		return nil, nil, fmt.Errorf(config.ERROR_NotSupportedAlgorithm, algorithm)
+	}
+}
+
+func makePEMKeys(pubkey any, privatekey any) ([]byte, []byte, error) {
+	public, err := makePublicPEMKey(pubkey)
 	if err != nil {
-		log.Fatal(err)
+		return nil, nil, err
+	}
+
+	key, err := x509.MarshalPKCS8PrivateKey(privatekey)
+	if err != nil {
+		return nil, nil, err
 	}
+	private := pem.EncodeToMemory(&pem.Block{
+		Type:  "PRIVATE KEY",
+		Bytes: key,
+	})
 
-	k.public = k.makePublicPEMKey(&key.PublicKey)
-	k.private = k.makePrivatePEMKey(key)
+	return public, private, nil
 }
 
-func (k *Certs) makePrivatePEMKey(privatekey *rsa.PrivateKey) []byte {
+func makePrivatePEMKey(privatekey *rsa.PrivateKey) []byte {
 	pemkey := &pem.Block{
 		Type:  "PRIVATE KEY",
 		Bytes: x509.MarshalPKCS1PrivateKey(privatekey),
@@ -83,14 +143,14 @@ func (k *Certs) makePrivatePEMKey(privatekey *rsa.PrivateKey) []byte {
 	return pem.EncodeToMemory(pemkey)
 }
 
-func (k *Certs) makePublicPEMKey(pubkey *rsa.PublicKey) []byte {
+func makePublicPEMKey(pubkey any) ([]byte, error) {
 	key, err := x509.MarshalPKIXPublicKey(pubkey)
 	if err != nil {
-		log.Fatal(err)
+		return nil, err
 	}
 	var pemkey = &pem.Block{
 		Type:  "PUBLIC KEY",
 		Bytes: key,
 	}
-	return pem.EncodeToMemory(pemkey)
+	return pem.EncodeToMemory(pemkey), nil
 }
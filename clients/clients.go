@@ -2,12 +2,16 @@ package clients
 
 import (
 	"context"
+	"errors"
+	"path"
+	"sort"
 
 	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/keyring"
+	"github.com/Moranilt/jwt-http2/tlscert"
 	capi "github.com/hashicorp/consul/api"
 	vault "github.com/hashicorp/vault/api"
 	"github.com/mitchellh/mapstructure"
-	"github.com/redis/go-redis/v9"
 )
 
 type VaultClient struct {
@@ -24,13 +28,9 @@ type VaultCfg struct {
 	Host            string
 }
 
-type RedisCreds struct {
-	Host     string `mapstructure:"host"`
-	Password string `mapstructure:"password"`
-}
-
 type CertificateValue struct {
-	Key string `mapstructure:"key"`
+	Key       string `mapstructure:"key"`
+	Algorithm string `mapstructure:"alg"`
 }
 
 // New Vault client
@@ -69,46 +69,165 @@ func (v *VaultClient) GetRedisCreds(ctx context.Context) (*RedisCreds, error) {
 	return creds, nil
 }
 
-func (v *VaultClient) GetPublicCert(ctx context.Context) ([]byte, error) {
-	kvSecret, err := v.client.KVv2(v.cfg.MountPath).Get(ctx, v.cfg.PublicCertPath)
+// TenantKeys returns store of tenant keys. Keys of tenant are stored by
+// paths of certificates with tenant id: <path>/<tenant>.
+func (v *VaultClient) TenantKeys(tenant string) keyring.Store {
+	if tenant == config.DEFAULT_Tenant {
+		return v
+	}
+
+	cfg := *v.cfg
+	cfg.PublicCertPath = path.Join(cfg.PublicCertPath, tenant)
+	cfg.PrivateCertPath = path.Join(cfg.PrivateCertPath, tenant)
+	return &VaultClient{
+		client: v.client,
+		cfg:    &cfg,
+	}
+}
+
+// GetKeys returns active key with up to retired previous public keys.
+// Previous keys are the previous versions of public certificate in Vault.
+// No keys are returned if certificates are not stored yet.
+func (v *VaultClient) GetKeys(ctx context.Context, retired int) ([]*keyring.Key, error) {
+	kv := v.client.KVv2(v.cfg.MountPath)
+
+	public, err := kv.Get(ctx, v.cfg.PublicCertPath)
+	if errors.Is(err, vault.ErrSecretNotFound) {
+		return nil, nil
+	}
 	if err != nil {
 		return nil, err
 	}
-	var cert *CertificateValue
-	err = mapstructure.Decode(kvSecret.Data, &cert)
+	publicCert, err := decodeCertValue(public)
 	if err != nil {
 		return nil, err
 	}
 
-	return []byte(cert.Key), nil
-}
+	private, err := kv.Get(ctx, v.cfg.PrivateCertPath)
+	if err != nil {
+		return nil, err
+	}
+	privateCert, err := decodeCertValue(private)
+	if err != nil {
+		return nil, err
+	}
 
-func (v *VaultClient) GetPrivateCert(ctx context.Context) ([]byte, error) {
-	kvSecret, err := v.client.KVv2(v.cfg.MountPath).Get(ctx, v.cfg.PrivateCertPath)
+	active, err := keyring.NewKey(publicCert.Algorithm, []byte(publicCert.Key), []byte(privateCert.Key), public.VersionMetadata.CreatedTime)
 	if err != nil {
 		return nil, err
 	}
-	var cert *CertificateValue
-	err = mapstructure.Decode(kvSecret.Data, &cert)
+	keys := []*keyring.Key{active}
+
+	versions, err := kv.GetVersionsAsList(ctx, v.cfg.PublicCertPath)
 	if err != nil {
 		return nil, err
 	}
+	sort.Slice(versions, func(i, j int) bool {
+		return versions[i].Version > versions[j].Version
+	})
+
+	for _, version := range versions {
+		if len(keys) > retired {
+			break
+		}
+		if version.Version >= public.VersionMetadata.Version || version.Destroyed || !version.DeletionTime.IsZero() {
+			continue
+		}
+
+		secret, err := kv.GetVersion(ctx, v.cfg.PublicCertPath, version.Version)
+		if err != nil {
+			return nil, err
+		}
+		cert, err := decodeCertValue(secret)
+		if err != nil {
+			return nil, err
+		}
+		key, err := keyring.NewKey(cert.Algorithm, []byte(cert.Key), nil, version.CreatedTime)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
 
-	return []byte(cert.Key), nil
+	return keys, nil
 }
 
-func Redis(ctx context.Context, creds *RedisCreds) (*redis.Client, error) {
-	redisClient := redis.NewClient(&redis.Options{
-		Addr:     creds.Host,
-		Password: creds.Password,
+// PutKeys stores new version of keys. Pair read in the middle of this call
+// would not match and is rejected by keyring.NewKey.
+func (v *VaultClient) PutKeys(ctx context.Context, algorithm string, public, private []byte) error {
+	kv := v.client.KVv2(v.cfg.MountPath)
+
+	_, err := kv.Put(ctx, v.cfg.PublicCertPath, map[string]interface{}{
+		"key": string(public),
+		"alg": algorithm,
 	})
+	if err != nil {
+		return err
+	}
 
-	if ping := redisClient.Ping(ctx); ping.Err() != nil {
-		return nil, ping.Err()
+	_, err = kv.Put(ctx, v.cfg.PrivateCertPath, map[string]interface{}{
+		"key": string(private),
+		"alg": algorithm,
+	})
+	if err != nil {
+		return err
+	}
+
+	return nil
+}
+
+// TLSCertificate is a certificate of gRPC server stored in Vault.
+type TLSCertificate struct {
+	Cert     string `mapstructure:"cert"`
+	Key      string `mapstructure:"key"`
+	ClientCA string `mapstructure:"ca"`
+}
+
+// TLSCerts returns source of gRPC server certificate stored in secret by path.
+func (v *VaultClient) TLSCerts(path string) tlscert.Source {
+	return &vaultTLSSource{
+		client: v,
+		path:   path,
+	}
+}
+
+type vaultTLSSource struct {
+	client *VaultClient
+	path   string
+}
+
+func (s *vaultTLSSource) Load(ctx context.Context) (*tlscert.Material, error) {
+	kvSecret, err := s.client.client.KVv2(s.client.cfg.MountPath).Get(ctx, s.path)
+	if err != nil {
+		return nil, err
 	}
 
-	return redisClient, nil
+	var cert TLSCertificate
+	err = mapstructure.Decode(kvSecret.Data, &cert)
+	if err != nil {
+		return nil, err
+	}
+
+	return &tlscert.Material{
+		Cert:     []byte(cert.Cert),
+		Key:      []byte(cert.Key),
+		ClientCA: []byte(cert.ClientCA),
+	}, nil
+}
+
+// decodeCertValue decodes certificate. Certificates stored without algorithm are RS256.
+func decodeCertValue(secret *vault.KVSecret) (*CertificateValue, error) {
+	var cert *CertificateValue
+	err := mapstructure.Decode(secret.Data, &cert)
+	if err != nil {
+		return nil, err
+	}
+
+	if cert.Algorithm == "" {
+		cert.Algorithm = config.DEFAULT_Algorithm
+	}
 
+	return cert, nil
 }
 
 func Consul(ctx context.Context, env *config.ConsulEnv) (*capi.Client, error) {
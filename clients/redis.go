@@ -0,0 +1,176 @@
+package clients
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	REDIS_ModeSingle   = "single"
+	REDIS_ModeSentinel = "sentinel"
+	REDIS_ModeCluster  = "cluster"
+
+	ERROR_RedisMode       = "not supported redis mode %q"
+	ERROR_RedisHost       = "redis host is not provided"
+	ERROR_RedisAddresses  = "redis addresses are not provided for %s mode"
+	ERROR_RedisMasterName = "redis master name is not provided for sentinel mode"
+	ERROR_RedisClusterDB  = "redis cluster supports only db 0"
+	ERROR_RedisCA         = "cannot parse redis CA certificate"
+	ERROR_RedisClientCert = "cannot parse redis client certificate: %v"
+)
+
+// RedisCreds describes connection to single Redis, Redis behind Sentinel or
+// Redis Cluster.
+type RedisCreds struct {
+	// single, sentinel or cluster. Default is single.
+	Mode string `mapstructure:"mode"`
+	// address of single node
+	Host string `mapstructure:"host"`
+	// addresses of sentinels or seed nodes of cluster
+	Addresses  []string `mapstructure:"addresses"`
+	MasterName string   `mapstructure:"master_name"`
+
+	Username         string `mapstructure:"username"`
+	Password         string `mapstructure:"password"`
+	SentinelUsername string `mapstructure:"sentinel_username"`
+	SentinelPassword string `mapstructure:"sentinel_password"`
+	DB               int    `mapstructure:"db"`
+
+	TLS *RedisTLS `mapstructure:"tls"`
+
+	Prefix string `mapstructure:"prefix"`
+}
+
+// RedisTLS is a TLS config of Redis connection. Certificates are PEM encoded.
+type RedisTLS struct {
+	Enabled            bool   `mapstructure:"enabled"`
+	ServerName         string `mapstructure:"server_name"`
+	CA                 string `mapstructure:"ca"`
+	Cert               string `mapstructure:"cert"`
+	Key                string `mapstructure:"key"`
+	InsecureSkipVerify bool   `mapstructure:"insecure_skip_verify"`
+}
+
+// RedisCredsFromEnv makes creds of single Redis set by env without Vault.
+func RedisCredsFromEnv(env *config.RedisEnv) *RedisCreds {
+	return &RedisCreds{
+		Mode:     REDIS_ModeSingle,
+		Host:     env.Host,
+		Password: env.Password,
+		Prefix:   env.Prefix,
+	}
+}
+
+// KeyPrefix returns prefix of stored keys. In cluster mode prefix is a hash
+// tag, so keys changed by one script are in the same slot. All keys of the
+// service are in one slot: scripts change keys of session together with set
+// of user sessions, so keys can't be tagged by session.
+func (c *RedisCreds) KeyPrefix() string {
+	prefix := c.Prefix
+	if prefix == "" {
+		prefix = storage.DEFAULT_KeyPrefix
+	}
+	if c.Mode == REDIS_ModeCluster {
+		return "{" + prefix + "}"
+	}
+	return prefix
+}
+
+// Redis makes client of Redis topology described by creds.
+func Redis(ctx context.Context, creds *RedisCreds) (redis.UniversalClient, error) {
+	tlsConfig, err := creds.TLS.config()
+	if err != nil {
+		return nil, err
+	}
+
+	var client redis.UniversalClient
+	switch creds.Mode {
+	case "", REDIS_ModeSingle:
+		if creds.Host == "" {
+			return nil, errors.New(ERROR_RedisHost)
+		}
+		client = redis.NewClient(&redis.Options{
+			Addr:      creds.Host,
+			Username:  creds.Username,
+			Password:  creds.Password,
+			DB:        creds.DB,
+			TLSConfig: tlsConfig,
+		})
+	case REDIS_ModeSentinel:
+		if len(creds.Addresses) == 0 {
+			return nil, fmt.Errorf(ERROR_RedisAddresses, creds.Mode)
+		}
+		if creds.MasterName == "" {
+			return nil, errors.New(ERROR_RedisMasterName)
+		}
+		client = redis.NewFailoverClient(&redis.FailoverOptions{
+			MasterName:       creds.MasterName,
+			SentinelAddrs:    creds.Addresses,
+			SentinelUsername: creds.SentinelUsername,
+			SentinelPassword: creds.SentinelPassword,
+			Username:         creds.Username,
+			Password:         creds.Password,
+			DB:               creds.DB,
+			TLSConfig:        tlsConfig,
+		})
+	case REDIS_ModeCluster:
+		if len(creds.Addresses) == 0 {
+			return nil, fmt.Errorf(ERROR_RedisAddresses, creds.Mode)
+		}
+		if creds.DB != 0 {
+			return nil, errors.New(ERROR_RedisClusterDB)
+		}
+		client = redis.NewClusterClient(&redis.ClusterOptions{
+			Addrs:     creds.Addresses,
+			Username:  creds.Username,
+			Password:  creds.Password,
+			TLSConfig: tlsConfig,
+		})
+	default:
+		return nil, fmt.Errorf(ERROR_RedisMode, creds.Mode)
+	}
+
+	if ping := client.Ping(ctx); ping.Err() != nil {
+		client.Close()
+		return nil, ping.Err()
+	}
+
+	return client, nil
+}
+
+func (t *RedisTLS) config() (*tls.Config, error) {
+	if t == nil || !t.Enabled {
+		return nil, nil
+	}
+
+	config := &tls.Config{
+		MinVersion:         tls.VersionTLS12,
+		ServerName:         t.ServerName,
+		InsecureSkipVerify: t.InsecureSkipVerify,
+	}
+
+	if t.CA != "" {
+		pool := x509.NewCertPool()
+		if !pool.AppendCertsFromPEM([]byte(t.CA)) {
+			return nil, errors.New(ERROR_RedisCA)
+		}
+		config.RootCAs = pool
+	}
+
+	if t.Cert != "" || t.Key != "" {
+		cert, err := tls.X509KeyPair([]byte(t.Cert), []byte(t.Key))
+		if err != nil {
+			return nil, fmt.Errorf(ERROR_RedisClientCert, err)
+		}
+		config.Certificates = []tls.Certificate{cert}
+	}
+
+	return config, nil
+}
@@ -0,0 +1,97 @@
+package clients
+
+import (
+	"context"
+	"encoding/json"
+	"testing"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/mitchellh/mapstructure"
+)
+
+func TestRedis(t *testing.T) {
+	mr := miniredis.RunT(t)
+	ctx := context.Background()
+
+	client, err := Redis(ctx, &RedisCreds{Host: mr.Addr()})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer client.Close()
+
+	err = client.Set(ctx, "key", "value", 0).Err()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if value, _ := mr.Get("key"); value != "value" {
+		t.Errorf("not valid value %q, expected %q", value, "value")
+	}
+}
+
+func TestRedisNotValidCreds(t *testing.T) {
+	tests := []struct {
+		name  string
+		creds *RedisCreds
+		err   string
+	}{
+		{name: "unknown mode", creds: &RedisCreds{Mode: "replica", Host: "localhost:6379"}, err: `not supported redis mode "replica"`},
+		{name: "single without host", creds: &RedisCreds{}, err: ERROR_RedisHost},
+		{name: "sentinel without addresses", creds: &RedisCreds{Mode: REDIS_ModeSentinel, MasterName: "master"}, err: "redis addresses are not provided for sentinel mode"},
+		{name: "sentinel without master", creds: &RedisCreds{Mode: REDIS_ModeSentinel, Addresses: []string{"localhost:26379"}}, err: ERROR_RedisMasterName},
+		{name: "cluster without addresses", creds: &RedisCreds{Mode: REDIS_ModeCluster}, err: "redis addresses are not provided for cluster mode"},
+		{name: "cluster with db", creds: &RedisCreds{Mode: REDIS_ModeCluster, Addresses: []string{"localhost:7000"}, DB: 1}, err: ERROR_RedisClusterDB},
+		{name: "not valid CA", creds: &RedisCreds{Host: "localhost:6379", TLS: &RedisTLS{Enabled: true, CA: "not a certificate"}}, err: ERROR_RedisCA},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := Redis(context.Background(), test.creds)
+			if err == nil || err.Error() != test.err {
+				t.Errorf("not valid error %v, expected %q", err, test.err)
+			}
+		})
+	}
+}
+
+func TestRedisCredsDecode(t *testing.T) {
+	// Vault decodes numbers of secrets as json.Number
+	data := map[string]any{
+		"mode":        "sentinel",
+		"addresses":   []any{"sentinel-1:26379", "sentinel-2:26379"},
+		"master_name": "auth",
+		"username":    "auth",
+		"db":          json.Number("2"),
+		"tls":         map[string]any{"enabled": true, "server_name": "redis"},
+	}
+
+	var creds *RedisCreds
+	err := mapstructure.Decode(data, &creds)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if creds.Mode != REDIS_ModeSentinel || len(creds.Addresses) != 2 || creds.MasterName != "auth" || creds.DB != 2 {
+		t.Errorf("not valid creds %+v", creds)
+	}
+	if creds.TLS == nil || !creds.TLS.Enabled || creds.TLS.ServerName != "redis" {
+		t.Errorf("not valid tls %+v", creds.TLS)
+	}
+}
+
+func TestRedisCredsKeyPrefix(t *testing.T) {
+	tests := []struct {
+		creds    RedisCreds
+		expected string
+	}{
+		{creds: RedisCreds{}, expected: "auth"},
+		{creds: RedisCreds{Prefix: "sessions"}, expected: "sessions"},
+		{creds: RedisCreds{Mode: REDIS_ModeSentinel, Prefix: "sessions"}, expected: "sessions"},
+		{creds: RedisCreds{Mode: REDIS_ModeCluster}, expected: "{auth}"},
+	}
+
+	for _, test := range tests {
+		if prefix := test.creds.KeyPrefix(); prefix != test.expected {
+			t.Errorf("not valid prefix %q of %+v, expected %q", prefix, test.creds, test.expected)
+		}
+	}
+}
@@ -0,0 +1,67 @@
+// Command migrate-keys moves sessions stored by bare token UUIDs into
+// prefixed key layout. It reads the same env as the service.
+package main
+
+import (
+	"context"
+	"flag"
+
+	"github.com/Moranilt/jwt-http2/clients"
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/sirupsen/logrus"
+)
+
+func main() {
+	dryRun := flag.Bool("dry-run", false, "count keys without moving them")
+	flag.Parse()
+
+	log := logger.New()
+	ctx := context.Background()
+
+	env, err := config.ReadEnv()
+	if err != nil {
+		log.Fatalf("error while reading env: %v", err)
+	}
+
+	var redisCreds *clients.RedisCreds
+	switch {
+	case env.Vault != nil:
+		vaultClient, err := clients.Vault(env.Vault)
+		if err != nil {
+			log.Fatalf("vault client: %v", err)
+		}
+
+		redisCreds, err = vaultClient.GetRedisCreds(ctx)
+		if err != nil {
+			log.Fatalf("vault client: %v", err)
+		}
+	case env.Redis != nil:
+		redisCreds = clients.RedisCredsFromEnv(env.Redis)
+	default:
+		log.Fatalf("redis is not set: provide %s or %s", config.VAULT_HOST, config.REDIS_HOST)
+	}
+
+	redis, err := clients.Redis(ctx, redisCreds)
+	if err != nil {
+		log.Fatalf("redis client: %v", err)
+	}
+
+	schema := storage.NewKeySchema(redisCreds.KeyPrefix())
+	report, err := storage.MigrateKeys(ctx, redis, schema, *dryRun)
+	fields := logrus.Fields{
+		"prefix":  schema.Prefix,
+		"dry_run": *dryRun,
+		"access":  report.Access,
+		"refresh": report.Refresh,
+		"family":  report.Family,
+		"user":    report.User,
+		"skipped": report.Skipped,
+	}
+	if err != nil {
+		log.WithFields(fields).Fatalf("migrate keys: %v", err)
+	}
+
+	log.WithFields(fields).Info("keys migrated")
+}
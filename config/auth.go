@@ -0,0 +1,102 @@
+package config
+
+import (
+	"crypto/sha256"
+	"crypto/subtle"
+	"crypto/x509"
+	"encoding/hex"
+)
+
+const (
+	// AUTH_Any is a caller of rule which allows any identified caller and a
+	// rule of RPCs without their own rule.
+	AUTH_Any = "*"
+	// AUTH_RotateKeys is a rule of keys rotation endpoint of REST API. It
+	// is not covered by rule of AUTH_Any, callers must be listed explicitly.
+	AUTH_RotateKeys = "RotateKeys"
+	// AUTH_Config is a rule of config endpoints of REST API. It is not
+	// covered by rule of AUTH_Any, callers must be listed explicitly.
+	AUTH_Config = "Config"
+)
+
+// Auth configures callers of Authentication RPCs. Callers are identified by
+// API key or by client certificate of mutual TLS. Rules list callers allowed
+// to call RPC by its name. Authorization is off without auth config.
+type Auth struct {
+	Callers map[string]*Caller  `yaml:"callers"`
+	Rules   map[string][]string `yaml:"rules"`
+}
+
+// Caller is an identity of service. APIKeys are SHA-256 hex of API keys, so
+// keys are not stored in config. Certificates are names of client
+// certificates: common name, DNS or URI of subject alternative names.
+type Caller struct {
+	APIKeys      []string `yaml:"api_keys"`
+	Certificates []string `yaml:"certificates"`
+}
+
+// HashAPIKey returns SHA-256 hex of API key as it is stored in config.
+func HashAPIKey(key string) string {
+	sum := sha256.Sum256([]byte(key))
+	return hex.EncodeToString(sum[:])
+}
+
+// CallerByAPIKey returns name of caller with API key.
+func (a *Auth) CallerByAPIKey(key string) (string, bool) {
+	hash := []byte(HashAPIKey(key))
+	for name, caller := range a.Callers {
+		if caller == nil {
+			continue
+		}
+		for _, h := range caller.APIKeys {
+			if subtle.ConstantTimeCompare(hash, []byte(h)) == 1 {
+				return name, true
+			}
+		}
+	}
+	return "", false
+}
+
+// CallerByCertificate returns name of caller with any name of certificate.
+func (a *Auth) CallerByCertificate(cert *x509.Certificate) (string, bool) {
+	names := []string{cert.Subject.CommonName}
+	names = append(names, cert.DNSNames...)
+	for _, uri := range cert.URIs {
+		names = append(names, uri.String())
+	}
+
+	for name, caller := range a.Callers {
+		if caller == nil {
+			continue
+		}
+		for _, c := range caller.Certificates {
+			for _, n := range names {
+				if n != "" && c == n {
+					return name, true
+				}
+			}
+		}
+	}
+	return "", false
+}
+
+// AdminRule reports whether rule is a rule of admin endpoint of REST API.
+// Admin endpoints are allowed only to listed callers.
+func AdminRule(rpc string) bool {
+	return rpc == AUTH_RotateKeys || rpc == AUTH_Config
+}
+
+// Allowed reports whether caller may call RPC. Rule of AUTH_Any is used for
+// RPCs without their own rule, except rules of admin endpoints.
+func (a *Auth) Allowed(rpc string, caller string) bool {
+	callers, ok := a.Rules[rpc]
+	if !ok && !AdminRule(rpc) {
+		callers = a.Rules[AUTH_Any]
+	}
+	for _, c := range callers {
+		if c == AUTH_Any || c == caller {
+			return true
+		}
+	}
+	return false
+}
@@ -2,32 +2,118 @@ package config
 
 import (
 	"context"
+	"crypto/sha256"
 	"encoding/base64"
+	"errors"
 	"fmt"
+	"net/netip"
+	"sort"
 	"sync"
 	"time"
 
 	"github.com/Moranilt/jwt-http2/logger"
 	"github.com/Moranilt/jwt-http2/utils"
-	capi "github.com/hashicorp/consul/api"
 	"gopkg.in/yaml.v2"
 )
 
+const (
+	ALGORITHM_RS256 = "RS256"
+	ALGORITHM_PS256 = "PS256"
+	ALGORITHM_ES256 = "ES256"
+	ALGORITHM_EdDSA = "EdDSA"
+
+	DEFAULT_Algorithm = ALGORITHM_RS256
+	// DEFAULT_Tenant is an id of tenant configured by root of config
+	DEFAULT_Tenant = ""
+
+	ERROR_NotSupportedAlgorithm = "not supported algorithm %q. Expected RS256, PS256, ES256, EdDSA"
+	ERROR_TTLRequired           = "ttl is required"
+)
+
 type TokenTime interface {
 	time.Duration | string
 }
+
+// Provider gives current application config. Config is replaced on every
+// update, so it must not be cached by consumers.
+type Provider interface {
+	Current() *AppConfig[time.Duration]
+	// Subscribe calls fn with every new config until unsubscribe is called.
+	Subscribe(fn func(*AppConfig[time.Duration])) (unsubscribe func())
+}
+
 type Config struct {
-	App    *AppConfig[time.Duration]
-	base64 string
-	mu     sync.RWMutex
-	log    *logger.Logger
+	app         *AppConfig[time.Duration]
+	subscribers map[int]func(*AppConfig[time.Duration])
+	nextID      int
+	mu          sync.RWMutex
+
+	// update serializes updates of config and base64 of the last one
+	update  sync.Mutex
+	base64  string
+	version Version
+
+	log *logger.Logger
 }
 
 type AppConfig[T TokenTime] struct {
-	Issuer   string   `yaml:"issuer"`
-	Subject  string   `yaml:"subject"`
-	Audience []string `yaml:"audience"`
-	TTL      *TTL[T]  `yaml:"ttl"`
+	Issuer    string   `yaml:"issuer"`
+	Subject   string   `yaml:"subject"`
+	Audience  []string `yaml:"audience"`
+	Algorithm string   `yaml:"algorithm"`
+	TTL       *TTL[T]  `yaml:"ttl"`
+	Keys      *Keys[T] `yaml:"keys"`
+	// Tenants are configs of other issuers by tenant id. Settings which are
+	// not set by tenant are taken from root config, except issuer.
+	Tenants map[string]*AppConfig[T] `yaml:"tenants,omitempty"`
+	// Auth is configured only in root config and is shared by tenants.
+	Auth *Auth `yaml:"auth,omitempty"`
+	// TrustedProxies are IPs or CIDRs of proxies which set X-Forwarded-For.
+	// It is configured only in root config.
+	TrustedProxies []string `yaml:"trusted_proxies,omitempty"`
+}
+
+// Tenant returns config of tenant. Root config is a config of default tenant.
+func (a *AppConfig[T]) Tenant(id string) (*AppConfig[T], bool) {
+	if id == DEFAULT_Tenant {
+		return a, true
+	}
+	tenant, ok := a.Tenants[id]
+	return tenant, ok && tenant != nil
+}
+
+// TrustedProxy reports whether address is an address of trusted proxy.
+func (a *AppConfig[T]) TrustedProxy(addr netip.Addr) bool {
+	for _, proxy := range a.TrustedProxies {
+		prefix, err := parseProxy(proxy)
+		if err == nil && prefix.Contains(addr.Unmap()) {
+			return true
+		}
+	}
+	return false
+}
+
+// parseProxy parses IP or CIDR of trusted proxy.
+func parseProxy(proxy string) (netip.Prefix, error) {
+	if addr, err := netip.ParseAddr(proxy); err == nil {
+		addr = addr.Unmap()
+		return netip.PrefixFrom(addr, addr.BitLen()), nil
+	}
+	prefix, err := netip.ParsePrefix(proxy)
+	if err != nil {
+		return netip.Prefix{}, err
+	}
+	return prefix.Masked(), nil
+}
+
+// TenantIDs returns sorted ids of all tenants. Default tenant is first.
+func (a *AppConfig[T]) TenantIDs() []string {
+	ids := make([]string, 0, len(a.Tenants)+1)
+	for id := range a.Tenants {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+	return append([]string{DEFAULT_Tenant}, ids...)
 }
 
 type TTL[T TokenTime] struct {
@@ -35,6 +121,22 @@ type TTL[T TokenTime] struct {
 	Refresh T `yaml:"refresh"`
 }
 
+// Keys configures rotation of signing keys. Rotation is an interval of scheduled
+// rotation, zero value turns it off. Retired is a number of previous keys
+// still used to verify tokens.
+type Keys[T TokenTime] struct {
+	Rotation T   `yaml:"rotation"`
+	Retired  int `yaml:"retired"`
+}
+
+// Version describes active config. Revision is a SHA-256 of config value, so
+// the same config has the same revision in every source.
+type Version struct {
+	Source    string    `json:"source"`
+	Revision  string    `json:"revision"`
+	UpdatedAt time.Time `json:"updated_at"`
+}
+
 type WatchConsulBody struct {
 	Key         string
 	CreateIndex int
@@ -44,29 +146,41 @@ type WatchConsulBody struct {
 
 func New(log *logger.Logger) *Config {
 	return &Config{
-		log: log,
+		subscribers: make(map[int]func(*AppConfig[time.Duration])),
+		log:         log,
 	}
 }
 
-func (c *Config) ReadConsul(ctx context.Context, consulKey string, cc *capi.Client) error {
-	kv := cc.KV()
-	pair, _, err := kv.Get(consulKey, nil)
-	if err != nil {
-		return err
-	}
+// Current returns the last applied config.
+func (c *Config) Current() *AppConfig[time.Duration] {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.app
+}
 
-	if pair == nil {
-		return fmt.Errorf("empty data in consul %q", consulKey)
-	}
+// Version returns version of the last applied config.
+func (c *Config) Version() Version {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.version
+}
 
-	err = c.setNewConfig(pair.Value)
-	if err != nil {
-		return err
-	}
+func (c *Config) Subscribe(fn func(*AppConfig[time.Duration])) func() {
+	c.mu.Lock()
+	defer c.mu.Unlock()
 
-	return nil
+	id := c.nextID
+	c.nextID++
+	c.subscribers[id] = fn
+
+	return func() {
+		c.mu.Lock()
+		defer c.mu.Unlock()
+		delete(c.subscribers, id)
+	}
 }
 
+// WatchConsul applies config sent by Consul watch to webhook.
 func (c *Config) WatchConsul(ctx context.Context, consulKey string, newConfigs []WatchConsulBody) error {
 	var consulConfig *WatchConsulBody
 	for _, nc := range newConfigs {
@@ -79,57 +193,199 @@ func (c *Config) WatchConsul(ctx context.Context, consulKey string, newConfigs [
 		return nil
 	}
 
-	c.mu.Lock()
-	if consulConfig.Value == c.base64 {
+	base64Decoded, err := base64.StdEncoding.DecodeString(consulConfig.Value)
+	if err != nil {
+		return err
+	}
+
+	return c.apply(SOURCE_Consul+":"+consulKey, base64Decoded)
+}
+
+// Validate parses and validates value without applying it.
+func (c *Config) Validate(value []byte) (*AppConfig[time.Duration], error) {
+	return parse(value)
+}
+
+// apply sets new config of source if value was changed. Not valid config is
+// rejected and the last valid config stays active.
+func (c *Config) apply(source string, value []byte) error {
+	c.update.Lock()
+	defer c.update.Unlock()
+
+	encoded := base64.StdEncoding.EncodeToString(value)
+	if encoded == c.base64 {
 		return nil
-	} else {
-		c.base64 = consulConfig.Value
 	}
 
-	base64Decoded, err := base64.StdEncoding.DecodeString(consulConfig.Value)
+	err := c.setNewConfig(value, Version{
+		Source:    source,
+		Revision:  fmt.Sprintf("%x", sha256.Sum256(value)),
+		UpdatedAt: time.Now(),
+	})
 	if err != nil {
 		return err
 	}
-	c.mu.Unlock()
+	c.base64 = encoded
+
+	c.log.Infof("New settings: %#v", c.Current())
 
-	err = c.setNewConfig(base64Decoded)
+	return nil
+}
+
+// setNewConfig parses and applies config. Must be called with locked update.
+func (c *Config) setNewConfig(newValue []byte, version Version) error {
+	app, err := parse(newValue)
 	if err != nil {
 		return err
 	}
 
-	c.log.Infof("New settings: %#v", c.App)
+	c.mu.Lock()
+	c.app = app
+	c.version = version
+	subscribers := make([]func(*AppConfig[time.Duration]), 0, len(c.subscribers))
+	for _, fn := range c.subscribers {
+		subscribers = append(subscribers, fn)
+	}
+	c.mu.Unlock()
+
+	for _, fn := range subscribers {
+		fn(app)
+	}
 
 	return nil
 }
 
-func (c *Config) setNewConfig(newValue []byte) error {
+// parse parses YAML config, sets defaults and validates it.
+func parse(value []byte) (*AppConfig[time.Duration], error) {
 	var newConfig *AppConfig[string]
-	err := yaml.Unmarshal(newValue, &newConfig)
+	err := yaml.Unmarshal(value, &newConfig)
 	if err != nil {
-		return err
+		return nil, err
+	}
+	if newConfig == nil {
+		return nil, errors.New(ERROR_TTLRequired)
+	}
+
+	app, err := convert(newConfig)
+	if err != nil {
+		return nil, err
+	}
+
+	app.Auth = newConfig.Auth
+	app.TrustedProxies = newConfig.TrustedProxies
+
+	if len(newConfig.Tenants) > 0 {
+		app.Tenants = make(map[string]*AppConfig[time.Duration], len(newConfig.Tenants))
+		for id, tenant := range newConfig.Tenants {
+			if tenant == nil {
+				tenant = &AppConfig[string]{}
+			}
+			if tenant.Auth != nil {
+				return nil, ValidationError{fmt.Sprintf(ERROR_TenantPrefix, id, ERROR_TenantAuth)}
+			}
+			if tenant.TrustedProxies != nil {
+				return nil, ValidationError{fmt.Sprintf(ERROR_TenantPrefix, id, ERROR_TenantProxies)}
+			}
+			app.Tenants[id], err = convert(inherit(tenant, newConfig))
+			if err != nil {
+				return nil, fmt.Errorf("tenant %q: %w", id, err)
+			}
+		}
+	}
+
+	err = Validate(app)
+	if err != nil {
+		return nil, err
+	}
+
+	return app, nil
+}
+
+// convert parses durations and sets default algorithm. Tenants are not
+// converted.
+func convert(newConfig *AppConfig[string]) (*AppConfig[time.Duration], error) {
+	if newConfig.TTL == nil {
+		return nil, errors.New(ERROR_TTLRequired)
 	}
 
 	access, err := utils.MakeTimeFromString(newConfig.TTL.Access)
 	if err != nil {
-		return fmt.Errorf("access TTL: %w", err)
+		return nil, fmt.Errorf("access TTL: %w", err)
 	}
 
 	refresh, err := utils.MakeTimeFromString(newConfig.TTL.Refresh)
 	if err != nil {
-		return fmt.Errorf("refresh TTL: %w", err)
+		return nil, fmt.Errorf("refresh TTL: %w", err)
 	}
 
-	c.mu.Lock()
-	c.App = &AppConfig[time.Duration]{
-		Issuer:   newConfig.Issuer,
-		Subject:  newConfig.Subject,
-		Audience: newConfig.Audience,
+	algorithm := newConfig.Algorithm
+	switch algorithm {
+	case "":
+		algorithm = DEFAULT_Algorithm
+	case ALGORITHM_RS256, ALGORITHM_PS256, ALGORITHM_ES256, ALGORITHM_EdDSA:
+	default:
+		return nil, fmt.Errorf(ERROR_NotSupportedAlgorithm, algorithm)
+	}
+
+	var keys *Keys[time.Duration]
+	if newConfig.Keys != nil {
+		keys = &Keys[time.Duration]{
+			Retired: newConfig.Keys.Retired,
+		}
+		if newConfig.Keys.Rotation != "" {
+			keys.Rotation, err = utils.MakeTimeFromString(newConfig.Keys.Rotation)
+			if err != nil {
+				return nil, fmt.Errorf("keys rotation: %w", err)
+			}
+		}
+	}
+
+	return &AppConfig[time.Duration]{
+		Issuer:    newConfig.Issuer,
+		Subject:   newConfig.Subject,
+		Audience:  newConfig.Audience,
+		Algorithm: algorithm,
 		TTL: &TTL[time.Duration]{
 			Access:  access,
 			Refresh: refresh,
 		},
+		Keys: keys,
+	}, nil
+}
+
+// inherit fills settings of tenant which are not set from root config.
+// Issuer is not inherited, every tenant has its own.
+func inherit(tenant, root *AppConfig[string]) *AppConfig[string] {
+	result := *tenant
+	result.Tenants = nil
+
+	if result.Subject == "" {
+		result.Subject = root.Subject
+	}
+	if len(result.Audience) == 0 {
+		result.Audience = root.Audience
+	}
+	if result.Algorithm == "" {
+		result.Algorithm = root.Algorithm
+	}
+	if result.Keys == nil {
+		result.Keys = root.Keys
 	}
-	c.mu.Unlock()
 
-	return nil
+	if root.TTL != nil {
+		if result.TTL == nil {
+			result.TTL = root.TTL
+		} else {
+			ttl := *result.TTL
+			if ttl.Access == "" {
+				ttl.Access = root.TTL.Access
+			}
+			if ttl.Refresh == "" {
+				ttl.Refresh = root.TTL.Refresh
+			}
+			result.TTL = &ttl
+		}
+	}
+
+	return &result
 }
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/logger"
+)
+
+const testKey = "auth/v1.0.0/config.yaml"
+
+func newTestConfig() *Config {
+	log := logger.New()
+	log.Out = io.Discard
+	return New(log)
+}
+
+func watchBody(value string) []WatchConsulBody {
+	return []WatchConsulBody{
+		{Key: "other/config.yaml", Value: base64.StdEncoding.EncodeToString([]byte("ttl: {}"))},
+		{Key: testKey, Value: value},
+	}
+}
+
+func TestWatchConsul(t *testing.T) {
+	c := newTestConfig()
+	ctx := context.Background()
+
+	var updates []*AppConfig[time.Duration]
+	unsubscribe := c.Subscribe(func(app *AppConfig[time.Duration]) {
+		updates = append(updates, app)
+	})
+
+	value := base64.StdEncoding.EncodeToString([]byte("issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 15m\n  refresh: 1d\n"))
+	// the same value is applied once
+	for i := 0; i < 2; i++ {
+		err := c.WatchConsul(ctx, testKey, watchBody(value))
+		if err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	if len(updates) != 1 {
+		t.Fatalf("not valid updates count %d, expected 1", len(updates))
+	}
+	if updates[0] != c.Current() || c.Current().TTL.Access != 15*time.Minute || c.Current().Algorithm != DEFAULT_Algorithm {
+		t.Errorf("not valid config %+v", c.Current())
+	}
+
+	// errors do not keep config locked
+	for i := 0; i < 2; i++ {
+		err := c.WatchConsul(ctx, testKey, watchBody("not base64"))
+		if err == nil {
+			t.Fatal("expected error for not valid base64")
+		}
+	}
+
+	unsubscribe()
+	value = base64.StdEncoding.EncodeToString([]byte("issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 5m\n  refresh: 1d\n"))
+	err := c.WatchConsul(ctx, testKey, watchBody(value))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if c.Current().TTL.Access != 5*time.Minute {
+		t.Errorf("not valid access ttl %s, expected %s", c.Current().TTL.Access, 5*time.Minute)
+	}
+	if len(updates) != 1 {
+		t.Errorf("unsubscribed function was called")
+	}
+}
@@ -4,7 +4,9 @@ import (
 	"fmt"
 	"os"
 	"strings"
+	"time"
 
+	"github.com/Moranilt/jwt-http2/utils"
 	"github.com/mitchellh/mapstructure"
 )
 
@@ -13,11 +15,16 @@ const (
 	PORT_REST  = "PORT_REST"
 	PRODUCTION = "PRODUCTION"
 
+	CONFIG_SOURCE = "CONFIG_SOURCE"
+	CONFIG_FILE   = "CONFIG_FILE"
+
 	CONSUL_HOST        = "CONSUL_HOST"
 	CONSUL_TOKEN       = "CONSUL_TOKEN"
 	CONSUL_KEY_FOLDER  = "CONSUL_KEY_FOLDER"
 	CONSUL_KEY_VERSION = "CONSUL_KEY_VERSION"
 	CONSUL_KEY_FILE    = "CONSUL_KEY_FILE"
+	CONSUL_WATCH_MODE  = "CONSUL_WATCH_MODE"
+	CONSUL_WATCH_TOKEN = "CONSUL_WATCH_TOKEN"
 
 	TRACER_URL  = "TRACER_URL"
 	TRACER_NAME = "TRACER_NAME"
@@ -28,6 +35,24 @@ const (
 	VAULT_REDIS_CREDS_PATH  = "VAULT_REDIS_CREDS_PATH"
 	VAULT_TOKEN             = "VAULT_TOKEN"
 	VAULT_HOST              = "VAULT_HOST"
+
+	REDIS_HOST     = "REDIS_HOST"
+	REDIS_PASSWORD = "REDIS_PASSWORD"
+	REDIS_PREFIX   = "REDIS_PREFIX"
+
+	GRPC_TLS_CERT_FILE       = "GRPC_TLS_CERT_FILE"
+	GRPC_TLS_KEY_FILE        = "GRPC_TLS_KEY_FILE"
+	GRPC_TLS_CLIENT_CA_FILE  = "GRPC_TLS_CLIENT_CA_FILE"
+	GRPC_TLS_VAULT_PATH      = "GRPC_TLS_VAULT_PATH"
+	GRPC_TLS_CLIENT_AUTH     = "GRPC_TLS_CLIENT_AUTH"
+	GRPC_TLS_RELOAD_INTERVAL = "GRPC_TLS_RELOAD_INTERVAL"
+
+	LOG_REDACT_CLAIMS = "LOG_REDACT_CLAIMS"
+
+	TLS_ClientAuthNone    = "none"
+	TLS_ClientAuthRequire = "require"
+	DEFAULT_TLSReload     = "1m"
+	DEFAULT_RedactClaims  = "*"
 )
 
 type VaultEnv struct {
@@ -45,6 +70,10 @@ type ConsulEnv struct {
 	KeyFolder  string `mapstructure:"CONSUL_KEY_FOLDER"`
 	KeyVersion string `mapstructure:"CONSUL_KEY_VERSION"`
 	KeyFile    string `mapstructure:"CONSUL_KEY_FILE"`
+	// WatchMode is blocking or webhook
+	WatchMode string `mapstructure:"CONSUL_WATCH_MODE"`
+	// WatchToken is a bearer token of webhook requests
+	WatchToken string `mapstructure:"CONSUL_WATCH_TOKEN"`
 }
 
 func (c *ConsulEnv) Key() string {
@@ -56,74 +85,196 @@ type JaegerEnv struct {
 	Name string `mapstructure:"TRACER_NAME"`
 }
 
+// RedisEnv is a connection to single Redis used without Vault.
+type RedisEnv struct {
+	Host     string `mapstructure:"REDIS_HOST"`
+	Password string `mapstructure:"REDIS_PASSWORD"`
+	Prefix   string `mapstructure:"REDIS_PREFIX"`
+}
+
+// TLSEnv is a certificate of gRPC server. Certificate is read from files or
+// from Vault secret with cert, key and ca fields if VaultPath is set.
+type TLSEnv struct {
+	CertFile     string `mapstructure:"GRPC_TLS_CERT_FILE"`
+	KeyFile      string `mapstructure:"GRPC_TLS_KEY_FILE"`
+	ClientCAFile string `mapstructure:"GRPC_TLS_CLIENT_CA_FILE"`
+	VaultPath    string `mapstructure:"GRPC_TLS_VAULT_PATH"`
+	// ClientAuth is none, optional or require. Default is require with
+	// client CA file and none without it.
+	ClientAuth     string        `mapstructure:"GRPC_TLS_CLIENT_AUTH"`
+	ReloadInterval time.Duration `mapstructure:"-"`
+}
+
+// Env is a set of env variables. Vault is nil without VAULT_HOST, Consul is
+// nil if config source is not consul, Jaeger is nil without TRACER_URL,
+// Redis is nil without REDIS_HOST and TLS is nil without GRPC_TLS_CERT_FILE
+// or GRPC_TLS_VAULT_PATH.
 type Env struct {
 	Vault      *VaultEnv
 	Consul     *ConsulEnv
 	Jaeger     *JaegerEnv
+	Redis      *RedisEnv
+	TLS        *TLSEnv
+	Source     string
+	File       string
 	PortGRPC   string
 	PortREST   string
 	Production bool
+
+	// RedactClaims are keys of user claims which are not logged
+	RedactClaims []string
 }
 
+// ReadEnv reads env variables. Only variables of used services are required.
 func ReadEnv() (*Env, error) {
-	keys := []string{
-		PORT_GRPC,
-		PORT_REST,
-		PRODUCTION,
-		CONSUL_HOST,
-		CONSUL_TOKEN,
-		CONSUL_KEY_FOLDER,
-		CONSUL_KEY_VERSION,
-		CONSUL_KEY_FILE,
-		TRACER_URL,
-		TRACER_NAME,
-		VAULT_MOUNT_PATH,
-		VAULT_PUBLIC_CERT_PATH,
-		VAULT_PRIVATE_CERT_PATH,
-		VAULT_REDIS_CREDS_PATH,
-		VAULT_TOKEN,
-		VAULT_HOST,
+	result := make(map[string]string)
+
+	err := lookupEnv(result, PORT_GRPC, PORT_REST)
+	if err != nil {
+		return nil, err
 	}
 
-	result := make(map[string]string, len(keys))
+	env := &Env{
+		Source:     getEnv(CONFIG_SOURCE, SOURCE_Consul),
+		File:       getEnv(CONFIG_FILE, DEFAULT_ConfigFile),
+		PortGRPC:   result[PORT_GRPC],
+		PortREST:   result[PORT_REST],
+		Production: os.Getenv(PRODUCTION) == "true",
+	}
+	for _, key := range strings.Split(getEnv(LOG_REDACT_CLAIMS, DEFAULT_RedactClaims), ",") {
+		if key = strings.TrimSpace(key); key != "" {
+			env.RedactClaims = append(env.RedactClaims, key)
+		}
+	}
 
-	for _, key := range keys {
-		if val, err := os.LookupEnv(key); !err {
-			return nil, fmt.Errorf("env %q is not provided", key)
-		} else {
-			result[key] = val
+	switch env.Source {
+	case SOURCE_Consul:
+		err = lookupEnv(result, CONSUL_HOST, CONSUL_KEY_FOLDER, CONSUL_KEY_VERSION, CONSUL_KEY_FILE)
+		if err != nil {
+			return nil, err
 		}
+		result[CONSUL_TOKEN] = os.Getenv(CONSUL_TOKEN)
+		result[CONSUL_WATCH_MODE] = getEnv(CONSUL_WATCH_MODE, WATCH_ModeBlocking)
+		result[CONSUL_WATCH_TOKEN] = os.Getenv(CONSUL_WATCH_TOKEN)
+
+		err = mapstructure.Decode(result, &env.Consul)
+		if err != nil {
+			return nil, err
+		}
+		if env.Consul.WatchMode != WATCH_ModeBlocking && env.Consul.WatchMode != WATCH_ModeWebhook {
+			return nil, fmt.Errorf("env %q must be %q or %q", CONSUL_WATCH_MODE, WATCH_ModeBlocking, WATCH_ModeWebhook)
+		}
+		if env.Consul.WatchMode == WATCH_ModeWebhook && env.Consul.WatchToken == "" {
+			return nil, fmt.Errorf("env %q is required in %s mode", CONSUL_WATCH_TOKEN, WATCH_ModeWebhook)
+		}
+	case SOURCE_File, SOURCE_Env:
+	default:
+		return nil, fmt.Errorf("env %q must be %q, %q or %q", CONFIG_SOURCE, SOURCE_Consul, SOURCE_File, SOURCE_Env)
 	}
 
-	var consul *ConsulEnv
-	err := mapstructure.Decode(result, &consul)
+	if os.Getenv(VAULT_HOST) != "" {
+		err = lookupEnv(result,
+			VAULT_MOUNT_PATH,
+			VAULT_PUBLIC_CERT_PATH,
+			VAULT_PRIVATE_CERT_PATH,
+			VAULT_REDIS_CREDS_PATH,
+			VAULT_TOKEN,
+			VAULT_HOST,
+		)
+		if err != nil {
+			return nil, err
+		}
+
+		err = mapstructure.Decode(result, &env.Vault)
+		if err != nil {
+			return nil, err
+		}
+	} else if os.Getenv(REDIS_HOST) != "" {
+		result[REDIS_HOST] = os.Getenv(REDIS_HOST)
+		result[REDIS_PASSWORD] = os.Getenv(REDIS_PASSWORD)
+		result[REDIS_PREFIX] = os.Getenv(REDIS_PREFIX)
+
+		err = mapstructure.Decode(result, &env.Redis)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	if os.Getenv(TRACER_URL) != "" {
+		err = lookupEnv(result, TRACER_URL, TRACER_NAME)
+		if err != nil {
+			return nil, err
+		}
+
+		err = mapstructure.Decode(result, &env.Jaeger)
+		if err != nil {
+			return nil, err
+		}
+	}
+
+	env.TLS, err = readTLSEnv(env.Vault != nil)
 	if err != nil {
 		return nil, err
 	}
 
-	var vault *VaultEnv
-	err = mapstructure.Decode(result, &vault)
+	return env, nil
+}
+
+// readTLSEnv reads certificate of gRPC server. Nil is returned without
+// certificate.
+func readTLSEnv(withVault bool) (*TLSEnv, error) {
+	result := make(map[string]string)
+	switch {
+	case os.Getenv(GRPC_TLS_VAULT_PATH) != "":
+		if !withVault {
+			return nil, fmt.Errorf("env %q requires %q", GRPC_TLS_VAULT_PATH, VAULT_HOST)
+		}
+		result[GRPC_TLS_VAULT_PATH] = os.Getenv(GRPC_TLS_VAULT_PATH)
+		result[GRPC_TLS_CLIENT_AUTH] = getEnv(GRPC_TLS_CLIENT_AUTH, TLS_ClientAuthRequire)
+	case os.Getenv(GRPC_TLS_CERT_FILE) != "":
+		err := lookupEnv(result, GRPC_TLS_CERT_FILE, GRPC_TLS_KEY_FILE)
+		if err != nil {
+			return nil, err
+		}
+		result[GRPC_TLS_CLIENT_CA_FILE] = os.Getenv(GRPC_TLS_CLIENT_CA_FILE)
+		clientAuth := TLS_ClientAuthNone
+		if result[GRPC_TLS_CLIENT_CA_FILE] != "" {
+			clientAuth = TLS_ClientAuthRequire
+		}
+		result[GRPC_TLS_CLIENT_AUTH] = getEnv(GRPC_TLS_CLIENT_AUTH, clientAuth)
+	default:
+		return nil, nil
+	}
+
+	var env *TLSEnv
+	err := mapstructure.Decode(result, &env)
 	if err != nil {
 		return nil, err
 	}
 
-	var jaeger *JaegerEnv
-	err = mapstructure.Decode(result, &jaeger)
+	env.ReloadInterval, err = utils.MakeTimeFromString(getEnv(GRPC_TLS_RELOAD_INTERVAL, DEFAULT_TLSReload))
 	if err != nil {
-		return nil, err
+		return nil, fmt.Errorf("env %q: %w", GRPC_TLS_RELOAD_INTERVAL, err)
 	}
 
-	var production bool
-	if result[PRODUCTION] == "true" {
-		production = true
+	return env, nil
+}
+
+// lookupEnv reads required env variables to result.
+func lookupEnv(result map[string]string, keys ...string) error {
+	for _, key := range keys {
+		val, ok := os.LookupEnv(key)
+		if !ok {
+			return fmt.Errorf("env %q is not provided", key)
+		}
+		result[key] = val
 	}
+	return nil
+}
 
-	return &Env{
-		Vault:      vault,
-		Consul:     consul,
-		Jaeger:     jaeger,
-		PortGRPC:   result[PORT_GRPC],
-		PortREST:   result[PORT_REST],
-		Production: production,
-	}, nil
+func getEnv(key string, defaultValue string) string {
+	if val := os.Getenv(key); val != "" {
+		return val
+	}
+	return defaultValue
 }
@@ -0,0 +1,40 @@
+package config
+
+import "context"
+
+const (
+	SOURCE_Consul = "consul"
+	SOURCE_File   = "file"
+	SOURCE_Env    = "env"
+)
+
+// Source loads YAML config of application and watches its changes.
+type Source interface {
+	// Name identifies source in version of config.
+	Name() string
+	// Load returns current config.
+	Load(ctx context.Context) ([]byte, error)
+	// Watch calls update with every new config until ctx is done.
+	Watch(ctx context.Context, update func(value []byte)) error
+}
+
+// Load applies config of source.
+func (c *Config) Load(ctx context.Context, source Source) error {
+	value, err := source.Load(ctx)
+	if err != nil {
+		return err
+	}
+
+	return c.apply(source.Name(), value)
+}
+
+// Watch applies changes of source until ctx is done. Not valid config is
+// logged and current config is kept.
+func (c *Config) Watch(ctx context.Context, source Source) error {
+	return source.Watch(ctx, func(value []byte) {
+		err := c.apply(source.Name(), value)
+		if err != nil {
+			c.log.Errorf("config: %v", err)
+		}
+	})
+}
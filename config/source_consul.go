@@ -0,0 +1,102 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/logger"
+	capi "github.com/hashicorp/consul/api"
+)
+
+const (
+	WATCH_ModeBlocking = "blocking"
+	WATCH_ModeWebhook  = "webhook"
+
+	// WATCH_WaitTime is a max duration of one blocking query
+	WATCH_WaitTime   = 5 * time.Minute
+	WATCH_MinBackoff = time.Second
+	WATCH_MaxBackoff = time.Minute
+)
+
+// ConsulSource reads config from Consul KV.
+type ConsulSource struct {
+	kv  *capi.KV
+	key string
+	log *logger.Logger
+}
+
+func NewConsulSource(log *logger.Logger, cc *capi.Client, key string) *ConsulSource {
+	return &ConsulSource{
+		kv:  cc.KV(),
+		key: key,
+		log: log,
+	}
+}
+
+func (s *ConsulSource) Name() string {
+	return SOURCE_Consul + ":" + s.key
+}
+
+func (s *ConsulSource) Load(ctx context.Context) ([]byte, error) {
+	pair, _, err := s.kv.Get(s.key, (&capi.QueryOptions{}).WithContext(ctx))
+	if err != nil {
+		return nil, err
+	}
+
+	if pair == nil {
+		return nil, fmt.Errorf("empty data in consul %q", s.key)
+	}
+
+	return pair.Value, nil
+}
+
+// Watch long-polls key with blocking queries. Failed queries are retried with
+// exponential backoff.
+func (s *ConsulSource) Watch(ctx context.Context, update func([]byte)) error {
+	backoff := WATCH_MinBackoff
+	var index uint64
+
+	for {
+		opts := &capi.QueryOptions{
+			WaitIndex: index,
+			WaitTime:  WATCH_WaitTime,
+		}
+		pair, meta, err := s.kv.Get(s.key, opts.WithContext(ctx))
+		if ctx.Err() != nil {
+			return nil
+		}
+		if err != nil {
+			s.log.Errorf("consul watch %q: %v. Retry in %s", s.key, err, backoff)
+			select {
+			case <-ctx.Done():
+				return nil
+			case <-time.After(backoff):
+			}
+			backoff *= 2
+			if backoff > WATCH_MaxBackoff {
+				backoff = WATCH_MaxBackoff
+			}
+			continue
+		}
+		backoff = WATCH_MinBackoff
+
+		// index can go backwards after snapshot restore of Consul and
+		// must be greater than zero to block
+		if meta.LastIndex < index {
+			index = 0
+		} else {
+			index = meta.LastIndex
+		}
+		if index == 0 {
+			index = 1
+		}
+
+		if pair == nil {
+			s.log.Warnf("consul watch: empty data in consul %q", s.key)
+			continue
+		}
+
+		update(pair.Value)
+	}
+}
@@ -0,0 +1,96 @@
+package config
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	capi "github.com/hashicorp/consul/api"
+)
+
+// newTestConsul serves values of key by blocking queries. The first query
+// fails to check retry.
+func newTestConsul(t *testing.T, key string, values <-chan string) *capi.Client {
+	var (
+		requests atomic.Int64
+		index    uint64
+		value    string
+	)
+
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Path != "/v1/kv/"+key {
+			http.NotFound(w, r)
+			return
+		}
+		if requests.Add(1) == 1 {
+			http.Error(w, "unavailable", http.StatusInternalServerError)
+			return
+		}
+
+		wait, _ := strconv.ParseUint(r.URL.Query().Get("index"), 10, 64)
+		if wait >= index {
+			select {
+			case value = <-values:
+				index++
+			case <-r.Context().Done():
+				return
+			}
+		}
+
+		w.Header().Set("X-Consul-Index", strconv.FormatUint(index, 10))
+		json.NewEncoder(w).Encode([]*capi.KVPair{{Key: key, Value: []byte(value), ModifyIndex: index}})
+	}))
+	t.Cleanup(server.Close)
+
+	client, err := capi.NewClient(&capi.Config{Address: strings.TrimPrefix(server.URL, "http://")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	return client
+}
+
+func TestConsulSourceWatch(t *testing.T) {
+	values := make(chan string)
+	client := newTestConsul(t, testKey, values)
+
+	c := newTestConfig()
+	updates := make(chan *AppConfig[time.Duration], 1)
+	c.Subscribe(func(app *AppConfig[time.Duration]) {
+		updates <- app
+	})
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan error)
+	go func() {
+		done <- c.Watch(ctx, NewConsulSource(c.log, client, testKey))
+	}()
+
+	for _, access := range []string{"15m", "5m"} {
+		values <- "issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: " + access + "\n  refresh: 1d\n"
+
+		select {
+		case app := <-updates:
+			if app.TTL.Access.String() != access+"0s" {
+				t.Errorf("not valid access ttl %s, expected %s", app.TTL.Access, access)
+			}
+		case <-time.After(5 * time.Second):
+			t.Fatalf("config with access ttl %s was not applied", access)
+		}
+	}
+
+	cancel()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("not expected error %v", err)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("watcher was not stopped")
+	}
+}
@@ -0,0 +1,74 @@
+package config
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v2"
+)
+
+const (
+	CONFIG_ISSUER        = "CONFIG_ISSUER"
+	CONFIG_SUBJECT       = "CONFIG_SUBJECT"
+	CONFIG_AUDIENCE      = "CONFIG_AUDIENCE"
+	CONFIG_ALGORITHM     = "CONFIG_ALGORITHM"
+	CONFIG_TTL_ACCESS    = "CONFIG_TTL_ACCESS"
+	CONFIG_TTL_REFRESH   = "CONFIG_TTL_REFRESH"
+	CONFIG_KEYS_ROTATION = "CONFIG_KEYS_ROTATION"
+	CONFIG_KEYS_RETIRED  = "CONFIG_KEYS_RETIRED"
+)
+
+// EnvSource reads config from CONFIG_* env variables. Audience is a comma
+// separated list. Env is not changed while app is running, so it is not
+// watched.
+type EnvSource struct{}
+
+func NewEnvSource() *EnvSource {
+	return &EnvSource{}
+}
+
+func (s *EnvSource) Name() string {
+	return SOURCE_Env
+}
+
+func (s *EnvSource) Load(ctx context.Context) ([]byte, error) {
+	config := AppConfig[string]{
+		Issuer:    os.Getenv(CONFIG_ISSUER),
+		Subject:   os.Getenv(CONFIG_SUBJECT),
+		Algorithm: os.Getenv(CONFIG_ALGORITHM),
+		TTL: &TTL[string]{
+			Access:  os.Getenv(CONFIG_TTL_ACCESS),
+			Refresh: os.Getenv(CONFIG_TTL_REFRESH),
+		},
+	}
+
+	if audience := os.Getenv(CONFIG_AUDIENCE); audience != "" {
+		for _, aud := range strings.Split(audience, ",") {
+			config.Audience = append(config.Audience, strings.TrimSpace(aud))
+		}
+	}
+
+	rotation, retired := os.Getenv(CONFIG_KEYS_ROTATION), os.Getenv(CONFIG_KEYS_RETIRED)
+	if rotation != "" || retired != "" {
+		config.Keys = &Keys[string]{
+			Rotation: rotation,
+		}
+		if retired != "" {
+			var err error
+			config.Keys.Retired, err = strconv.Atoi(retired)
+			if err != nil {
+				return nil, fmt.Errorf("env %q: %w", CONFIG_KEYS_RETIRED, err)
+			}
+		}
+	}
+
+	return yaml.Marshal(config)
+}
+
+func (s *EnvSource) Watch(ctx context.Context, update func([]byte)) error {
+	<-ctx.Done()
+	return nil
+}
@@ -0,0 +1,158 @@
+package config
+
+import (
+	"context"
+	"os"
+	"testing"
+	"time"
+)
+
+func TestEnvSource(t *testing.T) {
+	t.Setenv(CONFIG_ISSUER, "auth")
+	t.Setenv(CONFIG_SUBJECT, "user")
+	t.Setenv(CONFIG_AUDIENCE, "http://localhost:8080, http://localhost:8000")
+	t.Setenv(CONFIG_ALGORITHM, ALGORITHM_ES256)
+	t.Setenv(CONFIG_TTL_ACCESS, "15m")
+	t.Setenv(CONFIG_TTL_REFRESH, "7d")
+	t.Setenv(CONFIG_KEYS_ROTATION, "30d")
+	t.Setenv(CONFIG_KEYS_RETIRED, "2")
+
+	c := newTestConfig()
+	err := c.Load(context.Background(), NewEnvSource())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	app := c.Current()
+	if app.Issuer != "auth" || app.Subject != "user" || app.Algorithm != ALGORITHM_ES256 {
+		t.Errorf("not valid config %+v", app)
+	}
+	if len(app.Audience) != 2 || app.Audience[1] != "http://localhost:8000" {
+		t.Errorf("not valid audience %v", app.Audience)
+	}
+	if app.TTL.Access != 15*time.Minute || app.TTL.Refresh != 7*24*time.Hour {
+		t.Errorf("not valid ttl %+v", app.TTL)
+	}
+	if app.Keys == nil || app.Keys.Rotation != 30*24*time.Hour || app.Keys.Retired != 2 {
+		t.Errorf("not valid keys %+v", app.Keys)
+	}
+
+	t.Setenv(CONFIG_KEYS_RETIRED, "two")
+	_, err = NewEnvSource().Load(context.Background())
+	if err == nil {
+		t.Error("expected error for not valid retired keys")
+	}
+}
+
+func TestReadEnv(t *testing.T) {
+	tests := []struct {
+		name    string
+		env     map[string]string
+		wantErr bool
+		check   func(*Env) bool
+	}{
+		{
+			name: "file source without services",
+			env:  map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File},
+			check: func(env *Env) bool {
+				return env.Consul == nil && env.Vault == nil && env.Jaeger == nil && env.Redis == nil && env.File == DEFAULT_ConfigFile &&
+					len(env.RedactClaims) == 1 && env.RedactClaims[0] == DEFAULT_RedactClaims
+			},
+		},
+		{
+			name: "redacted claims",
+			env:  map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File, LOG_REDACT_CLAIMS: "email, phone,"},
+			check: func(env *Env) bool {
+				return len(env.RedactClaims) == 2 && env.RedactClaims[0] == "email" && env.RedactClaims[1] == "phone"
+			},
+		},
+		{
+			name: "env source with redis",
+			env:  map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_Env, REDIS_HOST: "localhost:6379"},
+			check: func(env *Env) bool {
+				return env.Redis != nil && env.Redis.Host == "localhost:6379"
+			},
+		},
+		{
+			name:    "consul source without consul",
+			env:     map[string]string{PORT_GRPC: "3000", PORT_REST: "4000"},
+			wantErr: true,
+		},
+		{
+			name: "consul source",
+			env: map[string]string{
+				PORT_GRPC: "3000", PORT_REST: "4000",
+				CONSUL_HOST: "localhost:8500", CONSUL_KEY_FOLDER: "auth", CONSUL_KEY_VERSION: "v1.0.0", CONSUL_KEY_FILE: "config.yaml",
+			},
+			check: func(env *Env) bool {
+				return env.Consul != nil && env.Consul.Key() == testKey && env.Consul.WatchMode == WATCH_ModeBlocking
+			},
+		},
+		{
+			name:    "vault without token",
+			env:     map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File, VAULT_HOST: "http://localhost:8200"},
+			wantErr: true,
+		},
+		{
+			name: "tls files with client CA",
+			env: map[string]string{
+				PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File,
+				GRPC_TLS_CERT_FILE: "server.crt", GRPC_TLS_KEY_FILE: "server.key", GRPC_TLS_CLIENT_CA_FILE: "ca.crt",
+			},
+			check: func(env *Env) bool {
+				return env.TLS != nil && env.TLS.ClientAuth == TLS_ClientAuthRequire && env.TLS.ReloadInterval == time.Minute
+			},
+		},
+		{
+			name: "tls files without client CA",
+			env: map[string]string{
+				PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File,
+				GRPC_TLS_CERT_FILE: "server.crt", GRPC_TLS_KEY_FILE: "server.key", GRPC_TLS_RELOAD_INTERVAL: "30s",
+			},
+			check: func(env *Env) bool {
+				return env.TLS != nil && env.TLS.ClientAuth == TLS_ClientAuthNone && env.TLS.ReloadInterval == 30*time.Second
+			},
+		},
+		{
+			name:    "tls certificate without key",
+			env:     map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File, GRPC_TLS_CERT_FILE: "server.crt"},
+			wantErr: true,
+		},
+		{
+			name:    "tls vault path without vault",
+			env:     map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: SOURCE_File, GRPC_TLS_VAULT_PATH: "grpc-tls"},
+			wantErr: true,
+		},
+		{
+			name:    "not valid source",
+			env:     map[string]string{PORT_GRPC: "3000", PORT_REST: "4000", CONFIG_SOURCE: "etcd"},
+			wantErr: true,
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			for _, key := range []string{
+				CONFIG_SOURCE, CONFIG_FILE, CONSUL_HOST, CONSUL_TOKEN, CONSUL_KEY_FOLDER, CONSUL_KEY_VERSION, CONSUL_KEY_FILE,
+				CONSUL_WATCH_MODE, CONSUL_WATCH_TOKEN, TRACER_URL, TRACER_NAME, VAULT_MOUNT_PATH, VAULT_PUBLIC_CERT_PATH,
+				VAULT_PRIVATE_CERT_PATH, VAULT_REDIS_CREDS_PATH, VAULT_TOKEN, VAULT_HOST, REDIS_HOST, REDIS_PASSWORD, REDIS_PREFIX,
+				GRPC_TLS_CERT_FILE, GRPC_TLS_KEY_FILE, GRPC_TLS_CLIENT_CA_FILE, GRPC_TLS_VAULT_PATH, GRPC_TLS_CLIENT_AUTH,
+				GRPC_TLS_RELOAD_INTERVAL, LOG_REDACT_CLAIMS,
+			} {
+				t.Setenv(key, "")
+				os.Unsetenv(key)
+			}
+			for key, value := range test.env {
+				t.Setenv(key, value)
+			}
+
+			env, err := ReadEnv()
+			if (err != nil) != test.wantErr {
+				t.Fatalf("not valid error %v, expected error %t", err, test.wantErr)
+			}
+			if test.check != nil && !test.check(env) {
+				t.Errorf("not valid env %+v", env)
+			}
+		})
+	}
+}
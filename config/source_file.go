@@ -0,0 +1,86 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/fsnotify/fsnotify"
+)
+
+const (
+	DEFAULT_ConfigFile = "config.yaml"
+
+	// FILE_Debounce groups events of one write of file
+	FILE_Debounce = 100 * time.Millisecond
+)
+
+// FileSource reads config from YAML file.
+type FileSource struct {
+	path string
+	log  *logger.Logger
+}
+
+func NewFileSource(log *logger.Logger, path string) *FileSource {
+	return &FileSource{
+		path: filepath.Clean(path),
+		log:  log,
+	}
+}
+
+func (s *FileSource) Name() string {
+	return SOURCE_File + ":" + s.path
+}
+
+func (s *FileSource) Load(ctx context.Context) ([]byte, error) {
+	return os.ReadFile(s.path)
+}
+
+// Watch reloads file on its changes. Directory of file is watched, so
+// replaced files and Kubernetes ConfigMap updates of ..data symlink are
+// noticed too.
+func (s *FileSource) Watch(ctx context.Context, update func([]byte)) error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+	defer watcher.Close()
+
+	err = watcher.Add(filepath.Dir(s.path))
+	if err != nil {
+		return err
+	}
+
+	reload := time.NewTimer(FILE_Debounce)
+	reload.Stop()
+	defer reload.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case event, ok := <-watcher.Events:
+			if !ok {
+				return nil
+			}
+			if filepath.Clean(event.Name) == s.path || strings.HasPrefix(filepath.Base(event.Name), "..") {
+				reload.Reset(FILE_Debounce)
+			}
+		case err, ok := <-watcher.Errors:
+			if !ok {
+				return nil
+			}
+			s.log.Errorf("file watch %q: %v", s.path, err)
+		case <-reload.C:
+			value, err := s.Load(ctx)
+			if err != nil {
+				s.log.Errorf("file watch %q: %v", s.path, err)
+				continue
+			}
+			update(value)
+		}
+	}
+}
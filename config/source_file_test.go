@@ -0,0 +1,86 @@
+package config
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestFileSourceWatch(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DEFAULT_ConfigFile)
+	err := os.WriteFile(path, []byte("issuer: first\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 15m\n  refresh: 1d\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConfig()
+	source := NewFileSource(c.log, path)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	err = c.Load(ctx, source)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if c.Current().Issuer != "first" {
+		t.Fatalf("not valid issuer %q, expected %q", c.Current().Issuer, "first")
+	}
+
+	updates := make(chan *AppConfig[time.Duration], 1)
+	c.Subscribe(func(app *AppConfig[time.Duration]) {
+		updates <- app
+	})
+
+	watchErr := make(chan error, 1)
+	go func() {
+		watchErr <- c.Watch(ctx, source)
+	}()
+
+	// watcher is started in goroutine, so file is written until it is noticed.
+	// Writes are less often than debounce, otherwise reload is postponed.
+	ticker := time.NewTicker(3 * FILE_Debounce)
+	defer ticker.Stop()
+	timeout := time.After(5 * time.Second)
+	for done := false; !done; {
+		select {
+		case app := <-updates:
+			if app.Issuer != "second" || app.TTL.Access != 30*time.Minute {
+				t.Fatalf("not valid config %+v", app)
+			}
+			done = true
+		case <-ticker.C:
+			err := os.WriteFile(path, []byte("issuer: second\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 30m\n  refresh: 1d\n"), 0o644)
+			if err != nil {
+				t.Fatal(err)
+			}
+		case <-timeout:
+			t.Fatal("config file change is not applied")
+		}
+	}
+
+	cancel()
+	if err := <-watchErr; err != nil {
+		t.Errorf("not valid watch error %v, expected nil", err)
+	}
+}
+
+func TestFileSourceNotValidConfig(t *testing.T) {
+	path := filepath.Join(t.TempDir(), DEFAULT_ConfigFile)
+	err := os.WriteFile(path, []byte("issuer: auth\n"), 0o644)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	c := newTestConfig()
+	err = c.Load(context.Background(), NewFileSource(c.log, path))
+	if err == nil || err.Error() != ERROR_TTLRequired {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_TTLRequired)
+	}
+
+	err = c.Load(context.Background(), NewFileSource(c.log, filepath.Join(t.TempDir(), "missing.yaml")))
+	if !os.IsNotExist(err) {
+		t.Errorf("not valid error %v, expected not exist", err)
+	}
+}
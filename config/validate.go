@@ -0,0 +1,215 @@
+package config
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"net/url"
+	"regexp"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+)
+
+const (
+	MIN_AccessTTL   = time.Minute
+	MAX_AccessTTL   = 24 * time.Hour
+	MAX_RefreshTTL  = 365 * 24 * time.Hour
+	MIN_KeyRotation = time.Hour
+	MAX_RetiredKeys = 10
+
+	ERROR_Required        = "%s is required"
+	ERROR_TTLBounds       = "%s must be from %s to %s, got %s"
+	ERROR_TTLOrder        = "ttl.refresh %s must be longer than ttl.access %s"
+	ERROR_AudienceURL     = "audience %q must be absolute URL"
+	ERROR_KeyRotation     = "keys.rotation must be at least %s, got %s"
+	ERROR_RetiredKeys     = "keys.retired must be from 0 to %d, got %d"
+	ERROR_KeysWindow      = "keys.rotation %s with keys.retired %d verifies tokens for %s, it must be at least ttl.refresh %s"
+	ERROR_ValidationTitle = "not valid config: "
+	ERROR_TenantID        = "not valid tenant id %q. Expected lowercase letters, digits, - and _ up to 64 symbols"
+	ERROR_TenantIssuer    = "issuer %q of tenant %q is already used by other tenant"
+	ERROR_TenantPrefix    = "tenant %q: %s"
+	ERROR_TenantAuth      = "auth is configured only in root config"
+	ERROR_TenantProxies   = "trusted_proxies is configured only in root config"
+	ERROR_TrustedProxy    = "trusted proxy %q must be IP or CIDR"
+	ERROR_AuthCaller      = "auth caller %q must have api_keys or certificates"
+	ERROR_AuthAPIKey      = "api key %d of auth caller %q must be lowercase SHA-256 hex"
+	ERROR_AuthDuplicate   = "%s %q is used by auth callers %q and %q"
+	ERROR_AuthRPC         = "auth rule of unknown RPC %q"
+	ERROR_AuthRuleCaller  = "auth rule of %q has unknown caller %q"
+)
+
+var tenantID = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]{0,63}$`)
+
+// ValidationError lists all problems of config.
+type ValidationError []string
+
+func (e ValidationError) Error() string {
+	return ERROR_ValidationTitle + strings.Join(e, "; ")
+}
+
+func (e *ValidationError) add(format string, args ...any) {
+	*e = append(*e, fmt.Sprintf(format, args...))
+}
+
+// Validate checks required fields, bounds and order of TTLs, URLs of
+// audience and keys settings of config and its tenants. Every tenant must
+// have its own issuer.
+func Validate(app *AppConfig[time.Duration]) error {
+	var errs ValidationError
+	validate(&errs, app)
+	if app.Auth != nil {
+		validateAuth(&errs, app.Auth)
+	}
+	for _, proxy := range app.TrustedProxies {
+		if _, err := parseProxy(proxy); err != nil {
+			errs.add(ERROR_TrustedProxy, proxy)
+		}
+	}
+
+	issuers := map[string]bool{app.Issuer: true}
+	for _, id := range app.TenantIDs()[1:] {
+		if !tenantID.MatchString(id) {
+			errs.add(ERROR_TenantID, id)
+		}
+
+		tenant, ok := app.Tenant(id)
+		if !ok {
+			continue
+		}
+		var tenantErrs ValidationError
+		validate(&tenantErrs, tenant)
+		for _, err := range tenantErrs {
+			errs.add(ERROR_TenantPrefix, id, err)
+		}
+
+		if tenant.Issuer != "" && issuers[tenant.Issuer] {
+			errs.add(ERROR_TenantIssuer, tenant.Issuer, id)
+		}
+		issuers[tenant.Issuer] = true
+	}
+
+	if len(errs) > 0 {
+		return errs
+	}
+	return nil
+}
+
+// validate checks settings of one tenant.
+func validate(errs *ValidationError, app *AppConfig[time.Duration]) {
+	if app.Issuer == "" {
+		errs.add(ERROR_Required, "issuer")
+	}
+	if app.Subject == "" {
+		errs.add(ERROR_Required, "subject")
+	}
+
+	if len(app.Audience) == 0 {
+		errs.add(ERROR_Required, "audience")
+	}
+	for _, aud := range app.Audience {
+		u, err := url.Parse(aud)
+		if err != nil || u.Scheme == "" || u.Host == "" {
+			errs.add(ERROR_AudienceURL, aud)
+		}
+	}
+
+	if app.TTL == nil {
+		errs.add(ERROR_TTLRequired)
+	} else {
+		if app.TTL.Access < MIN_AccessTTL || app.TTL.Access > MAX_AccessTTL {
+			errs.add(ERROR_TTLBounds, "ttl.access", MIN_AccessTTL, MAX_AccessTTL, app.TTL.Access)
+		}
+		if app.TTL.Refresh > MAX_RefreshTTL {
+			errs.add(ERROR_TTLBounds, "ttl.refresh", app.TTL.Access, MAX_RefreshTTL, app.TTL.Refresh)
+		}
+		if app.TTL.Refresh <= app.TTL.Access {
+			errs.add(ERROR_TTLOrder, app.TTL.Refresh, app.TTL.Access)
+		}
+	}
+
+	if app.Keys != nil {
+		if app.Keys.Rotation != 0 && app.Keys.Rotation < MIN_KeyRotation {
+			errs.add(ERROR_KeyRotation, MIN_KeyRotation, app.Keys.Rotation)
+		}
+		if app.Keys.Retired < 0 || app.Keys.Retired > MAX_RetiredKeys {
+			errs.add(ERROR_RetiredKeys, MAX_RetiredKeys, app.Keys.Retired)
+		} else if app.Keys.Rotation >= MIN_KeyRotation && app.TTL != nil && app.TTL.Refresh <= MAX_RefreshTTL {
+			// key verifies tokens while it is active and retired, keyring
+			// keeps 1 retired key by default
+			retired := app.Keys.Retired
+			if retired == 0 {
+				retired = 1
+			}
+			window := time.Duration(retired+1) * app.Keys.Rotation
+			if window < app.TTL.Refresh {
+				errs.add(ERROR_KeysWindow, app.Keys.Rotation, retired, window, app.TTL.Refresh)
+			}
+		}
+	}
+}
+
+// validateAuth checks that callers are identified and rules are made for
+// known RPCs and callers.
+func validateAuth(errs *ValidationError, auth *Auth) {
+	if len(auth.Callers) == 0 {
+		errs.add(ERROR_Required, "auth.callers")
+	}
+
+	names := make([]string, 0, len(auth.Callers))
+	for name := range auth.Callers {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	keys := make(map[string]string)
+	certs := make(map[string]string)
+	for _, name := range names {
+		caller := auth.Callers[name]
+		if caller == nil || len(caller.APIKeys)+len(caller.Certificates) == 0 {
+			errs.add(ERROR_AuthCaller, name)
+			continue
+		}
+
+		for i, key := range caller.APIKeys {
+			// hashes are compared with lowercase output of HashAPIKey
+			if b, err := hex.DecodeString(key); err != nil || len(b) != sha256.Size || key != strings.ToLower(key) {
+				errs.add(ERROR_AuthAPIKey, i, name)
+				continue
+			}
+			if other, ok := keys[key]; ok {
+				errs.add(ERROR_AuthDuplicate, "api key", key, other, name)
+			}
+			keys[key] = name
+		}
+		for _, cert := range caller.Certificates {
+			if other, ok := certs[cert]; ok {
+				errs.add(ERROR_AuthDuplicate, "certificate", cert, other, name)
+			}
+			certs[cert] = name
+		}
+	}
+
+	rpcs := map[string]bool{AUTH_Any: true, AUTH_RotateKeys: true, AUTH_Config: true}
+	for _, method := range jwt_gRPC.Authentication_ServiceDesc.Methods {
+		rpcs[method.MethodName] = true
+	}
+	rules := make([]string, 0, len(auth.Rules))
+	for rpc := range auth.Rules {
+		rules = append(rules, rpc)
+	}
+	sort.Strings(rules)
+
+	for _, rpc := range rules {
+		if !rpcs[rpc] {
+			errs.add(ERROR_AuthRPC, rpc)
+		}
+		for _, caller := range auth.Rules[rpc] {
+			if _, ok := auth.Callers[caller]; !ok && caller != AUTH_Any {
+				errs.add(ERROR_AuthRuleCaller, rpc, caller)
+			}
+		}
+	}
+}
@@ -0,0 +1,323 @@
+package config
+
+import (
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+)
+
+const testValidConfig = `
+issuer: auth
+subject: user
+audience:
+  - http://localhost:8080
+ttl:
+  access: 15m
+  refresh: 7d
+keys:
+  rotation: 30d
+  retired: 1
+`
+
+func TestValidate(t *testing.T) {
+	tests := []struct {
+		name   string
+		value  string
+		errors []string
+	}{
+		{name: "valid config", value: testValidConfig},
+		{
+			name:   "without issuer and subject",
+			value:  strings.NewReplacer("issuer: auth", "", "subject: user", "").Replace(testValidConfig),
+			errors: []string{"issuer is required", "subject is required"},
+		},
+		{
+			name:   "without audience",
+			value:  strings.Replace(testValidConfig, "  - http://localhost:8080", "", 1),
+			errors: []string{"audience is required"},
+		},
+		{
+			name:   "audience is not URL",
+			value:  strings.Replace(testValidConfig, "http://localhost:8080", "localhost", 1),
+			errors: []string{`audience "localhost" must be absolute URL`},
+		},
+		{
+			name:   "access ttl longer than refresh",
+			value:  strings.Replace(testValidConfig, "refresh: 7d", "refresh: 10m", 1),
+			errors: []string{"ttl.refresh 10m0s must be longer than ttl.access 15m0s"},
+		},
+		{
+			name:   "access ttl out of bounds",
+			value:  strings.Replace(testValidConfig, "access: 15m", "access: 30s", 1),
+			errors: []string{"ttl.access must be from 1m0s to 24h0m0s, got 30s"},
+		},
+		{
+			name:   "refresh ttl out of bounds",
+			value:  strings.Replace(testValidConfig, "refresh: 7d", "refresh: 400d", 1),
+			errors: []string{"ttl.refresh must be from 15m0s to 8760h0m0s, got 9600h0m0s"},
+		},
+		{
+			name:   "keys out of bounds",
+			value:  strings.NewReplacer("rotation: 30d", "rotation: 10m", "retired: 1", "retired: 11").Replace(testValidConfig),
+			errors: []string{"keys.rotation must be at least 1h0m0s, got 10m0s", "keys.retired must be from 0 to 10, got 11"},
+		},
+		{
+			name:   "keys are rotated faster than refresh ttl",
+			value:  strings.Replace(testValidConfig, "rotation: 30d", "rotation: 1h", 1),
+			errors: []string{"keys.rotation 1h0m0s with keys.retired 1 verifies tokens for 2h0m0s, it must be at least ttl.refresh 168h0m0s"},
+		},
+		{
+			name:  "trusted proxies",
+			value: testValidConfig + "trusted_proxies: [10.0.0.0/8, 192.0.2.1, \"2001:db8::/32\"]\n",
+		},
+		{
+			name:   "not valid trusted proxy",
+			value:  testValidConfig + "trusted_proxies: [10.0.0.0/33, proxy]\n",
+			errors: []string{fmt.Sprintf(ERROR_TrustedProxy, "10.0.0.0/33"), fmt.Sprintf(ERROR_TrustedProxy, "proxy")},
+		},
+	}
+
+	c := newTestConfig()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.Validate([]byte(test.value))
+			if len(test.errors) == 0 {
+				if err != nil {
+					t.Fatalf("not expected error %v", err)
+				}
+				return
+			}
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("not valid error %v, expected ValidationError", err)
+			}
+			if strings.Join(validationErr, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("not valid errors %q, expected %q", validationErr, test.errors)
+			}
+		})
+	}
+}
+
+func TestApplyKeepsLastValidConfig(t *testing.T) {
+	c := newTestConfig()
+	source := SOURCE_Consul + ":" + testKey
+
+	err := c.apply(source, []byte(testValidConfig))
+	if err != nil {
+		t.Fatal(err)
+	}
+	active, version := c.Current(), c.Version()
+	if version.Source != source || len(version.Revision) != 64 || version.UpdatedAt.IsZero() {
+		t.Errorf("not valid version %+v", version)
+	}
+
+	err = c.apply(source, []byte(strings.Replace(testValidConfig, "issuer: auth", "", 1)))
+	if err == nil {
+		t.Fatal("expected error for config without issuer")
+	}
+	if c.Current() != active || c.Version() != version {
+		t.Errorf("not valid config %+v, expected last valid config %+v", c.Current(), active)
+	}
+}
+
+func TestValidateTenants(t *testing.T) {
+	tests := []struct {
+		name    string
+		tenants string
+		errors  []string
+	}{
+		{
+			name:    "valid tenants",
+			tenants: "  shop:\n    issuer: shop\n  blog:\n    issuer: blog\n    ttl:\n      access: 5m\n",
+		},
+		{
+			name:    "not valid tenant id",
+			tenants: "  Shop:\n    issuer: shop\n",
+			errors:  []string{fmt.Sprintf(ERROR_TenantID, "Shop")},
+		},
+		{
+			name:    "issuer of other tenant",
+			tenants: "  shop:\n    issuer: auth\n",
+			errors:  []string{fmt.Sprintf(ERROR_TenantIssuer, "auth", "shop")},
+		},
+		{
+			name:    "not valid tenant config",
+			tenants: "  shop:\n    ttl:\n      refresh: 5m\n",
+			errors: []string{
+				`tenant "shop": issuer is required`,
+				`tenant "shop": ttl.refresh 5m0s must be longer than ttl.access 15m0s`,
+			},
+		},
+		{
+			name:    "tenant refresh ttl longer than inherited keys",
+			tenants: "  shop:\n    issuer: shop\n    ttl:\n      refresh: 90d\n",
+			errors: []string{
+				`tenant "shop": keys.rotation 720h0m0s with keys.retired 1 verifies tokens for 1440h0m0s, it must be at least ttl.refresh 2160h0m0s`,
+			},
+		},
+	}
+
+	c := newTestConfig()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.Validate([]byte(testValidConfig + "tenants:\n" + test.tenants))
+			if len(test.errors) == 0 {
+				if err != nil {
+					t.Fatalf("not expected error %v", err)
+				}
+				return
+			}
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("not valid error %v, expected ValidationError", err)
+			}
+			if strings.Join(validationErr, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("not valid errors %q, expected %q", validationErr, test.errors)
+			}
+		})
+	}
+}
+
+func TestTenantInheritsConfig(t *testing.T) {
+	c := newTestConfig()
+	app, err := c.Validate([]byte(testValidConfig + "tenants:\n  shop:\n    issuer: shop\n    ttl:\n      access: 5m\n"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shop, ok := app.Tenant("shop")
+	if !ok {
+		t.Fatal("tenant shop is not found")
+	}
+	if shop.Issuer != "shop" || shop.Subject != app.Subject || shop.Audience[0] != app.Audience[0] || shop.Algorithm != DEFAULT_Algorithm {
+		t.Errorf("not valid tenant config %+v", shop)
+	}
+	if shop.TTL.Access != 5*time.Minute || shop.TTL.Refresh != app.TTL.Refresh || shop.Keys.Rotation != app.Keys.Rotation {
+		t.Errorf("not valid tenant ttl %+v and keys %+v", shop.TTL, shop.Keys)
+	}
+
+	if ids := app.TenantIDs(); len(ids) != 2 || ids[0] != DEFAULT_Tenant || ids[1] != "shop" {
+		t.Errorf("not valid tenant ids %q", ids)
+	}
+	if _, ok := app.Tenant("blog"); ok {
+		t.Error("not expected tenant blog")
+	}
+}
+
+func TestValidateAuth(t *testing.T) {
+	loginKey := HashAPIKey("login-key")
+	tests := []struct {
+		name   string
+		auth   string
+		errors []string
+	}{
+		{
+			name: "valid auth",
+			auth: "  callers:\n    login:\n      api_keys: [" + loginKey + "]\n    orders:\n      certificates: [orders.internal]\n" +
+				"  rules:\n    CreateTokens: [login]\n    RotateKeys: [orders]\n    Config: [orders]\n    \"*\": [\"*\"]\n",
+		},
+		{
+			name:   "without callers",
+			auth:   "  rules:\n    CreateTokens: [login]\n",
+			errors: []string{"auth.callers is required", fmt.Sprintf(ERROR_AuthRuleCaller, "CreateTokens", "login")},
+		},
+		{
+			name:   "caller without identity",
+			auth:   "  callers:\n    login: {}\n",
+			errors: []string{fmt.Sprintf(ERROR_AuthCaller, "login")},
+		},
+		{
+			name:   "uppercase api key hash",
+			auth:   "  callers:\n    login:\n      api_keys: [" + strings.ToUpper(loginKey) + "]\n",
+			errors: []string{fmt.Sprintf(ERROR_AuthAPIKey, 0, "login")},
+		},
+		{
+			name:   "plain api key",
+			auth:   "  callers:\n    login:\n      api_keys: [login-key]\n",
+			errors: []string{fmt.Sprintf(ERROR_AuthAPIKey, 0, "login")},
+		},
+		{
+			name:   "api key of two callers",
+			auth:   "  callers:\n    login:\n      api_keys: [" + loginKey + "]\n    orders:\n      api_keys: [" + loginKey + "]\n",
+			errors: []string{fmt.Sprintf(ERROR_AuthDuplicate, "api key", loginKey, "login", "orders")},
+		},
+		{
+			name:   "unknown RPC",
+			auth:   "  callers:\n    login:\n      api_keys: [" + loginKey + "]\n  rules:\n    CreateToken: [login]\n",
+			errors: []string{fmt.Sprintf(ERROR_AuthRPC, "CreateToken")},
+		},
+	}
+
+	c := newTestConfig()
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := c.Validate([]byte(testValidConfig + "auth:\n" + test.auth))
+			if len(test.errors) == 0 {
+				if err != nil {
+					t.Fatalf("not expected error %v", err)
+				}
+				return
+			}
+
+			var validationErr ValidationError
+			if !errors.As(err, &validationErr) {
+				t.Fatalf("not valid error %v, expected ValidationError", err)
+			}
+			if strings.Join(validationErr, "\n") != strings.Join(test.errors, "\n") {
+				t.Errorf("not valid errors %q, expected %q", validationErr, test.errors)
+			}
+		})
+	}
+
+	_, err := c.Validate([]byte(testValidConfig + "tenants:\n  shop:\n    issuer: shop\n    auth:\n      rules: {}\n"))
+	if err == nil || !strings.Contains(err.Error(), ERROR_TenantAuth) {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_TenantAuth)
+	}
+
+	_, err = c.Validate([]byte(testValidConfig + "tenants:\n  shop:\n    issuer: shop\n    trusted_proxies: [10.0.0.1]\n"))
+	if err == nil || !strings.Contains(err.Error(), ERROR_TenantProxies) {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_TenantProxies)
+	}
+}
+
+func TestAuthAllowed(t *testing.T) {
+	auth := &Auth{
+		Callers: map[string]*Caller{
+			"login":  {APIKeys: []string{HashAPIKey("login-key")}},
+			"orders": {Certificates: []string{"spiffe://cluster/orders"}},
+		},
+		Rules: map[string][]string{
+			"CreateTokens": {"login"},
+			AUTH_Any:       {AUTH_Any},
+		},
+	}
+
+	tests := []struct {
+		rpc     string
+		caller  string
+		allowed bool
+	}{
+		{rpc: "CreateTokens", caller: "login", allowed: true},
+		{rpc: "CreateTokens", caller: "orders"},
+		{rpc: "GetUserId", caller: "orders", allowed: true},
+		// admin endpoints are not allowed by rule of any RPC
+		{rpc: AUTH_RotateKeys, caller: "orders"},
+		{rpc: AUTH_Config, caller: "orders"},
+	}
+	for _, test := range tests {
+		if allowed := auth.Allowed(test.rpc, test.caller); allowed != test.allowed {
+			t.Errorf("not valid allowed of %q to %s %t, expected %t", test.caller, test.rpc, allowed, test.allowed)
+		}
+	}
+
+	if caller, ok := auth.CallerByAPIKey("login-key"); !ok || caller != "login" {
+		t.Errorf("not valid caller of api key %q, expected %q", caller, "login")
+	}
+	if _, ok := auth.CallerByAPIKey("other-key"); ok {
+		t.Error("expected unknown api key")
+	}
+}
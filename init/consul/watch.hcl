@@ -1,3 +1,5 @@
+# Used only with CONSUL_WATCH_MODE=webhook. By default app watches its key by
+# blocking queries. Token must be equal to CONSUL_WATCH_TOKEN of app.
 watches = [
   {
     type = "keyprefix"
@@ -6,6 +8,9 @@ watches = [
     http_handler_config {
       path = "http://host.docker.internal:4000/watch"
       method = "POST"
+      header = {
+        "Authorization" = ["Bearer change-me"]
+      }
       timeout = "10s"
       tls_skip_verify = false
     }
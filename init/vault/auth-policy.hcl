@@ -1,3 +1,7 @@
 path "authentication/data/*" {
   capabilities = ["read", "create", "update"]
-}
\ No newline at end of file
+}
+
+path "authentication/metadata/*" {
+  capabilities = ["read"]
+}
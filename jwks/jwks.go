@@ -0,0 +1,127 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+)
+
+const (
+	USE_Signature = "sig"
+
+	KTY_RSA = "RSA"
+	KTY_EC  = "EC"
+	KTY_OKP = "OKP"
+
+	CRV_Ed25519 = "Ed25519"
+
+	ERROR_NotSupportedKey = "not supported public key type %T"
+)
+
+// Key is a JSON Web Key as described in RFC 7517.
+type Key struct {
+	Kty string `json:"kty"`
+	Kid string `json:"kid"`
+	Alg string `json:"alg"`
+	Use string `json:"use"`
+	N   string `json:"n,omitempty"`
+	E   string `json:"e,omitempty"`
+	Crv string `json:"crv,omitempty"`
+	X   string `json:"x,omitempty"`
+	Y   string `json:"y,omitempty"`
+}
+
+// Set is a JSON Web Key Set as described in RFC 7517.
+type Set struct {
+	Keys []Key `json:"keys"`
+}
+
+// FromPublicKey makes signature verification Key from RSA, ECDSA or Ed25519 public key.
+func FromPublicKey(kid string, alg string, public crypto.PublicKey) (*Key, error) {
+	key, err := members(public)
+	if err != nil {
+		return nil, err
+	}
+
+	key.Kid = kid
+	key.Alg = alg
+	key.Use = USE_Signature
+	return key, nil
+}
+
+// Thumbprint returns RFC 7638 thumbprint of public key. It is used as kid.
+func Thumbprint(public crypto.PublicKey) (string, error) {
+	key, err := members(public)
+	if err != nil {
+		return "", err
+	}
+
+	// only required members in lexicographic order
+	var required any
+	switch key.Kty {
+	case KTY_RSA:
+		required = struct {
+			E   string `json:"e"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+		}{key.E, key.Kty, key.N}
+	case KTY_EC:
+		required = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		}{key.Crv, key.Kty, key.X, key.Y}
+	case KTY_OKP:
+		required = struct {
+			Crv string `json:"crv"`
+			Kty string `json:"kty"`
+			X   string `json:"x"`
+		}{key.Crv, key.Kty, key.X}
+	}
+
+	b, err := json.Marshal(required)
+	if err != nil {
+		return "", err
+	}
+
+	sum := sha256.Sum256(b)
+	return base64.RawURLEncoding.EncodeToString(sum[:]), nil
+}
+
+func members(public crypto.PublicKey) (*Key, error) {
+	switch pub := public.(type) {
+	case *rsa.PublicKey:
+		return &Key{
+			Kty: KTY_RSA,
+			N:   encode(pub.N.Bytes()),
+			E:   encode(big.NewInt(int64(pub.E)).Bytes()),
+		}, nil
+	case *ecdsa.PublicKey:
+		size := (pub.Curve.Params().BitSize + 7) / 8
+		return &Key{
+			Kty: KTY_EC,
+			Crv: pub.Curve.Params().Name,
+			X:   encode(pub.X.FillBytes(make([]byte, size))),
+			Y:   encode(pub.Y.FillBytes(make([]byte, size))),
+		}, nil
+	case ed25519.PublicKey:
+		return &Key{
+			Kty: KTY_OKP,
+			Crv: CRV_Ed25519,
+			X:   encode(pub),
+		}, nil
+	default:
+		return nil, fmt.Errorf(ERROR_NotSupportedKey, public)
+	}
+}
+
+func encode(b []byte) string {
+	return base64.RawURLEncoding.EncodeToString(b)
+}
@@ -0,0 +1,105 @@
+package jwks
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/ed25519"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"encoding/base64"
+	"math/big"
+	"testing"
+)
+
+// RFC 7638 section 3.1 example key and its thumbprint
+const (
+	testRFCModulus    = "0vx7agoebGcQSuuPiLJXZptN9nndrQmbXEps2aiAFbWhM78LhWx4cbbfAAtVT86zwu1RK7aPFFxuhDR1L6tSoc_BJECPebWKRXjBZCiFV4n3oknjhMstn64tZ_2W-5JsGY4Hc5n9yBXArwl93lqt7_RN5w6Cf0h4QyQ5v-65YGjQR0_FDW2QvzqY368QQMicAtaSqzs8KJZgnYb9c7d0zgdAZHzu6qMQvRL5hajrn1n91CbOpbISD08qNLyrdkt-bFTWhAI4vMQFh6WeZu0fM4lFd2NcRwr3XPksINHaQ-G_xBniIqbw0Ls1jF44-csFCur-kEgU8awapJzKnqDKgw"
+	testRFCExponent   = "AQAB"
+	testRFCThumbprint = "NzbLsXh8uDCcd-6MNwXF4W_7noWXFZAfHkxZsRGC9Xs"
+)
+
+func testRFCKey(tb testing.TB) *rsa.PublicKey {
+	tb.Helper()
+
+	n, err := base64.RawURLEncoding.DecodeString(testRFCModulus)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	e, err := base64.RawURLEncoding.DecodeString(testRFCExponent)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+}
+
+func TestThumbprint(t *testing.T) {
+	thumbprint, err := Thumbprint(testRFCKey(t))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if thumbprint != testRFCThumbprint {
+		t.Errorf("not valid thumbprint %q, expected %q", thumbprint, testRFCThumbprint)
+	}
+
+	_, err = Thumbprint("key")
+	if err == nil {
+		t.Error("expected error for not supported key")
+	}
+}
+
+func TestFromPublicKey(t *testing.T) {
+	ecKey, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	edKey, _, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		alg    string
+		public crypto.PublicKey
+		kty    string
+		crv    string
+	}{
+		{name: "rsa", alg: "RS256", public: testRFCKey(t), kty: KTY_RSA},
+		{name: "ecdsa", alg: "ES256", public: &ecKey.PublicKey, kty: KTY_EC, crv: "P-256"},
+		{name: "ed25519", alg: "EdDSA", public: edKey, kty: KTY_OKP, crv: CRV_Ed25519},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			key, err := FromPublicKey("kid", test.alg, test.public)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if key.Kid != "kid" || key.Alg != test.alg || key.Use != USE_Signature || key.Kty != test.kty || key.Crv != test.crv {
+				t.Errorf("not valid key %+v", key)
+			}
+
+			switch test.kty {
+			case KTY_RSA:
+				if key.N != testRFCModulus || key.E != testRFCExponent {
+					t.Errorf("not valid n %q and e %q, expected %q and %q", key.N, key.E, testRFCModulus, testRFCExponent)
+				}
+			case KTY_EC:
+				// coordinates of P-256 are 32 bytes
+				if len(key.X) != 43 || len(key.Y) != 43 {
+					t.Errorf("not valid coordinates %q, %q", key.X, key.Y)
+				}
+			case KTY_OKP:
+				if key.X != base64.RawURLEncoding.EncodeToString(edKey) || key.Y != "" {
+					t.Errorf("not valid x %q and y %q", key.X, key.Y)
+				}
+			}
+		})
+	}
+
+	_, err = FromPublicKey("kid", "RS256", "key")
+	if err == nil {
+		t.Error("expected error for not supported key")
+	}
+}
@@ -0,0 +1,47 @@
+package keyring
+
+import (
+	"crypto"
+	"fmt"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// SigningMethod returns jwt.SigningMethod of algorithm.
+func SigningMethod(algorithm string) (jwt.SigningMethod, error) {
+	switch algorithm {
+	case config.ALGORITHM_RS256, config.ALGORITHM_PS256, config.ALGORITHM_ES256, config.ALGORITHM_EdDSA:
+		return jwt.GetSigningMethod(algorithm), nil
+	default:
+		return nil, fmt.Errorf(config.ERROR_NotSupportedAlgorithm, algorithm)
+	}
+}
+
+// ParsePublicKey parses PEM encoded public key of algorithm.
+func ParsePublicKey(algorithm string, public []byte) (crypto.PublicKey, error) {
+	switch algorithm {
+	case config.ALGORITHM_RS256, config.ALGORITHM_PS256:
+		return jwt.ParseRSAPublicKeyFromPEM(public)
+	case config.ALGORITHM_ES256:
+		return jwt.ParseECPublicKeyFromPEM(public)
+	case config.ALGORITHM_EdDSA:
+		return jwt.ParseEdPublicKeyFromPEM(public)
+	default:
+		return nil, fmt.Errorf(config.ERROR_NotSupportedAlgorithm, algorithm)
+	}
+}
+
+// ParsePrivateKey parses PEM encoded private key of algorithm.
+func ParsePrivateKey(algorithm string, private []byte) (crypto.PrivateKey, error) {
+	switch algorithm {
+	case config.ALGORITHM_RS256, config.ALGORITHM_PS256:
+		return jwt.ParseRSAPrivateKeyFromPEM(private)
+	case config.ALGORITHM_ES256:
+		return jwt.ParseECPrivateKeyFromPEM(private)
+	case config.ALGORITHM_EdDSA:
+		return jwt.ParseEdPrivateKeyFromPEM(private)
+	default:
+		return nil, fmt.Errorf(config.ERROR_NotSupportedAlgorithm, algorithm)
+	}
+}
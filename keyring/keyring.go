@@ -0,0 +1,138 @@
+package keyring
+
+import (
+	"crypto"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/jwks"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+const (
+	ERROR_ParsePublicKey  = "cannot parse public key: %v"
+	ERROR_ParsePrivateKey = "cannot parse private key: %v"
+	ERROR_KeyPairMismatch = "private key does not match public key %q"
+	ERROR_KeyAlgorithm    = "key %q does not match algorithm %q"
+	ERROR_NoActiveKey     = "keyring has no active key"
+)
+
+// Key is a parsed pair of keys. Signer is nil for retired keys which are only
+// used to verify tokens signed before rotation.
+type Key struct {
+	ID        string
+	Algorithm string
+	Method    jwt.SigningMethod
+	Public    crypto.PublicKey
+	Signer    crypto.Signer
+	CreatedAt time.Time
+}
+
+// NewKey parses PEM encoded keys of signing algorithm. ID is generated from
+// public key thumbprint.
+func NewKey(algorithm string, public, private []byte, createdAt time.Time) (*Key, error) {
+	method, err := SigningMethod(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	pub, err := ParsePublicKey(algorithm, public)
+	if err != nil {
+		return nil, fmt.Errorf(ERROR_ParsePublicKey, err)
+	}
+
+	kid, err := jwks.Thumbprint(pub)
+	if err != nil {
+		return nil, err
+	}
+
+	var signer crypto.Signer
+	if len(private) > 0 {
+		priv, err := ParsePrivateKey(algorithm, private)
+		if err != nil {
+			return nil, fmt.Errorf(ERROR_ParsePrivateKey, err)
+		}
+		var ok bool
+		signer, ok = priv.(crypto.Signer)
+		if !ok {
+			return nil, fmt.Errorf(ERROR_KeyAlgorithm, kid, algorithm)
+		}
+		if !signer.Public().(interface{ Equal(crypto.PublicKey) bool }).Equal(pub) {
+			return nil, fmt.Errorf(ERROR_KeyPairMismatch, kid)
+		}
+	}
+
+	return &Key{
+		ID:        kid,
+		Algorithm: algorithm,
+		Method:    method,
+		Public:    pub,
+		Signer:    signer,
+		CreatedAt: createdAt,
+	}, nil
+}
+
+// Keyring holds one active key to sign tokens and retired keys to verify them.
+type Keyring struct {
+	mu     sync.RWMutex
+	active *Key
+	keys   []*Key
+	byID   map[string]*Key
+}
+
+func New() *Keyring {
+	return &Keyring{
+		byID: make(map[string]*Key),
+	}
+}
+
+// Set replaces all keys of keyring.
+func (k *Keyring) Set(active *Key, retired ...*Key) error {
+	if active == nil || active.Signer == nil {
+		return errors.New(ERROR_NoActiveKey)
+	}
+
+	keys := make([]*Key, 0, len(retired)+1)
+	byID := make(map[string]*Key, len(retired)+1)
+	for _, key := range append([]*Key{active}, retired...) {
+		if _, ok := byID[key.ID]; ok {
+			continue
+		}
+		keys = append(keys, key)
+		byID[key.ID] = key
+	}
+
+	k.mu.Lock()
+	k.active = active
+	k.keys = keys
+	k.byID = byID
+	k.mu.Unlock()
+
+	return nil
+}
+
+// Active returns key to sign new tokens.
+func (k *Keyring) Active() *Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	return k.active
+}
+
+// Get returns key to verify token by its kid.
+func (k *Keyring) Get(id string) (*Key, bool) {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	key, ok := k.byID[id]
+	return key, ok
+}
+
+// Keys returns all verification keys. Active key is first.
+func (k *Keyring) Keys() []*Key {
+	k.mu.RLock()
+	defer k.mu.RUnlock()
+	keys := make([]*Key, len(k.keys))
+	copy(keys, k.keys)
+	return keys
+}
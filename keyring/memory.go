@@ -0,0 +1,60 @@
+package keyring
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+type storedKeys struct {
+	algorithm string
+	public    []byte
+	private   []byte
+	createdAt time.Time
+}
+
+// MemoryStore keeps keys in memory. Keys are lost on restart, so tokens are
+// valid only until restart of app. It is used without Vault.
+type MemoryStore struct {
+	mu       sync.RWMutex
+	versions []storedKeys
+}
+
+func NewMemoryStore() *MemoryStore {
+	return &MemoryStore{}
+}
+
+func (m *MemoryStore) GetKeys(ctx context.Context, retired int) ([]*Key, error) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	keys := make([]*Key, 0, retired+1)
+	for i := len(m.versions) - 1; i >= 0 && len(keys) <= retired; i-- {
+		version := m.versions[i]
+		var private []byte
+		if len(keys) == 0 {
+			private = version.private
+		}
+
+		key, err := NewKey(version.algorithm, version.public, private, version.createdAt)
+		if err != nil {
+			return nil, err
+		}
+		keys = append(keys, key)
+	}
+
+	return keys, nil
+}
+
+func (m *MemoryStore) PutKeys(ctx context.Context, algorithm string, public, private []byte) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.versions = append(m.versions, storedKeys{
+		algorithm: algorithm,
+		public:    public,
+		private:   private,
+		createdAt: time.Now(),
+	})
+	return nil
+}
@@ -0,0 +1,168 @@
+package keyring
+
+import (
+	"context"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/certs"
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	RELOAD_Interval = time.Minute
+
+	DEFAULT_RetiredKeys = 1
+)
+
+// Store loads and saves keys. First of loaded keys is active.
+type Store interface {
+	GetKeys(ctx context.Context, retired int) ([]*Key, error)
+	PutKeys(ctx context.Context, algorithm string, public, private []byte) error
+}
+
+// Rotator keeps Keyring of tenant in sync with Store and rotates active key.
+type Rotator struct {
+	ring   *Keyring
+	store  Store
+	log    *logger.Logger
+	config config.Provider
+	tenant string
+}
+
+func NewRotator(log *logger.Logger, ring *Keyring, store Store, config config.Provider, tenant string) *Rotator {
+	return &Rotator{
+		ring:   ring,
+		store:  store,
+		log:    log,
+		config: config,
+		tenant: tenant,
+	}
+}
+
+// Keyring returns keys of tenant.
+func (r *Rotator) Keyring() *Keyring {
+	return r.ring
+}
+
+// Init loads keys from Store. Active key is generated if Store has no keys.
+func (r *Rotator) Init(ctx context.Context) error {
+	keys, err := r.store.GetKeys(ctx, r.retired())
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		_, err = r.Rotate(ctx)
+		return err
+	}
+
+	return r.ring.Set(keys[0], keys[1:]...)
+}
+
+// Load reads keys from Store into Keyring.
+func (r *Rotator) Load(ctx context.Context) error {
+	keys, err := r.store.GetKeys(ctx, r.retired())
+	if err != nil {
+		return err
+	}
+
+	if len(keys) == 0 {
+		return r.ring.Set(nil)
+	}
+
+	return r.ring.Set(keys[0], keys[1:]...)
+}
+
+// Rotate generates new active key of configured algorithm. Previous active key
+// is kept to verify tokens.
+func (r *Rotator) Rotate(ctx context.Context) (*Key, error) {
+	algorithm := r.tenantConfig().Algorithm
+	public, private, err := certs.GenerateKeys(algorithm)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.store.PutKeys(ctx, algorithm, public, private)
+	if err != nil {
+		return nil, err
+	}
+
+	err = r.Load(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	active := r.ring.Active()
+	r.log.WithFields(logrus.Fields{
+		"tenant": r.tenant,
+		"kid":    active.ID,
+		"alg":    active.Algorithm,
+	}).Info("signing key rotated")
+
+	return active, nil
+}
+
+// Run reloads keys and rotates active key on schedule until ctx is done.
+func (r *Rotator) Run(ctx context.Context) error {
+	ticker := time.NewTicker(RELOAD_Interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+		}
+
+		r.reload(ctx)
+	}
+}
+
+// reload reloads keys from Store and rotates active key if rotation is due.
+// Rotation is decided by keys just loaded, so replicas sharing Store don't
+// rotate key which was already rotated by other replica.
+func (r *Rotator) reload(ctx context.Context) {
+	err := r.Load(ctx)
+	if err == nil && r.rotationDue() {
+		_, err = r.Rotate(ctx)
+	}
+	if err != nil {
+		r.log.WithField("tenant", r.tenant).Error("keyring: ", err)
+	}
+}
+
+// rotationDue reports if active key is older than rotation interval or
+// configured algorithm was changed.
+func (r *Rotator) rotationDue() bool {
+	cfg := r.tenantConfig()
+	active := r.ring.Active()
+	if active == nil || active.Algorithm != cfg.Algorithm {
+		return true
+	}
+
+	if cfg.Keys == nil || cfg.Keys.Rotation <= 0 {
+		return false
+	}
+
+	return time.Since(active.CreatedAt) >= cfg.Keys.Rotation
+}
+
+func (r *Rotator) retired() int {
+	keys := r.tenantConfig().Keys
+	if keys == nil || keys.Retired <= 0 {
+		return DEFAULT_RetiredKeys
+	}
+	return keys.Retired
+}
+
+// tenantConfig returns config of tenant. Root config is used if tenant was
+// removed from config.
+func (r *Rotator) tenantConfig() *config.AppConfig[time.Duration] {
+	cfg := r.config.Current()
+	if tenant, ok := cfg.Tenant(r.tenant); ok {
+		return tenant
+	}
+	return cfg
+}
@@ -0,0 +1,128 @@
+package keyring
+
+import (
+	"context"
+	"errors"
+	"io"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/golang-jwt/jwt/v5"
+)
+
+// testConfig is a config provider with constant config.
+type testConfig struct {
+	app *config.AppConfig[time.Duration]
+}
+
+func (c testConfig) Current() *config.AppConfig[time.Duration] {
+	return c.app
+}
+
+func (c testConfig) Subscribe(fn func(*config.AppConfig[time.Duration])) func() {
+	return func() {}
+}
+
+func newTestRotator(tb testing.TB, store Store, keys *config.Keys[time.Duration]) *Rotator {
+	tb.Helper()
+
+	log := logger.New()
+	log.Out = io.Discard
+	cfg := testConfig{app: &config.AppConfig[time.Duration]{
+		Algorithm: config.ALGORITHM_RS256,
+		Keys:      keys,
+	}}
+
+	r := NewRotator(log, New(), store, cfg, config.DEFAULT_Tenant)
+	err := r.Init(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return r
+}
+
+// signToken signs token by active key of keyring.
+func signToken(tb testing.TB, ring *Keyring) string {
+	tb.Helper()
+
+	active := ring.Active()
+	token := jwt.NewWithClaims(active.Method, jwt.MapClaims{"sub": "user"})
+	token.Header["kid"] = active.ID
+	signed, err := token.SignedString(active.Signer)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return signed
+}
+
+// verifyToken verifies token by key of keyring found by kid.
+func verifyToken(ring *Keyring, token string) error {
+	_, err := jwt.Parse(token, func(token *jwt.Token) (any, error) {
+		kid, _ := token.Header["kid"].(string)
+		key, ok := ring.Get(kid)
+		if !ok {
+			return nil, errors.New("unknown key id")
+		}
+		return key.Public, nil
+	})
+	return err
+}
+
+func TestRotateRetiredKeys(t *testing.T) {
+	ctx := context.Background()
+	r := newTestRotator(t, NewMemoryStore(), &config.Keys[time.Duration]{Retired: 1})
+
+	token := signToken(t, r.Keyring())
+	signedBy := r.Keyring().Active().ID
+
+	_, err := r.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if r.Keyring().Active().ID == signedBy {
+		t.Fatal("active key was not rotated")
+	}
+	err = verifyToken(r.Keyring(), token)
+	if err != nil {
+		t.Errorf("token signed before rotation: not expected error %v", err)
+	}
+
+	// key is out of retired keys after second rotation
+	_, err = r.Rotate(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = verifyToken(r.Keyring(), token)
+	if err == nil {
+		t.Error("expected error for token signed by removed key")
+	}
+	if keys := r.Keyring().Keys(); len(keys) != 2 {
+		t.Errorf("not valid count of keys %d, expected 2", len(keys))
+	}
+}
+
+func TestReloadSharedStore(t *testing.T) {
+	store := NewMemoryStore()
+	keys := &config.Keys[time.Duration]{Rotation: time.Hour, Retired: 1}
+	first := newTestRotator(t, store, keys)
+
+	// key is due to rotation in both replicas
+	store.versions[0].createdAt = time.Now().Add(-2 * time.Hour)
+	err := first.Load(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	second := newTestRotator(t, store, keys)
+
+	first.reload(context.Background())
+	second.reload(context.Background())
+
+	if len(store.versions) != 2 {
+		t.Errorf("not valid count of key versions %d, expected 2", len(store.versions))
+	}
+	if first.Keyring().Active().ID != second.Keyring().Active().ID {
+		t.Errorf("not valid active key %q, expected %q", second.Keyring().Active().ID, first.Keyring().Active().ID)
+	}
+}
@@ -0,0 +1,152 @@
+package keyring
+
+import (
+	"context"
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/logger"
+)
+
+const ERROR_UnknownTenant = "unknown tenant %q"
+
+// Tenants keeps keyring of every tenant of config. Keys of every tenant are
+// kept in their own Store and are rotated by settings of tenant.
+type Tenants struct {
+	log    *logger.Logger
+	config config.Provider
+	// store returns Store of tenant keys
+	store func(tenant string) Store
+
+	mu       sync.RWMutex
+	rotators map[string]*Rotator
+}
+
+func NewTenants(log *logger.Logger, config config.Provider, store func(tenant string) Store) *Tenants {
+	return &Tenants{
+		log:      log,
+		config:   config,
+		store:    store,
+		rotators: make(map[string]*Rotator),
+	}
+}
+
+// Keyring returns keys of tenant.
+func (t *Tenants) Keyring(tenant string) (*Keyring, bool) {
+	rotator, err := t.Rotator(tenant)
+	if err != nil {
+		return nil, false
+	}
+	return rotator.Keyring(), true
+}
+
+// Rotator returns rotator of tenant keys.
+func (t *Tenants) Rotator(tenant string) (*Rotator, error) {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rotator, ok := t.rotators[tenant]
+	if !ok {
+		return nil, fmt.Errorf(ERROR_UnknownTenant, tenant)
+	}
+	return rotator, nil
+}
+
+// Init makes keyrings of new tenants of config and removes keyrings of
+// removed tenants.
+func (t *Tenants) Init(ctx context.Context) error {
+	ids := t.config.Current().TenantIDs()
+
+	t.mu.RLock()
+	var added []string
+	for _, id := range ids {
+		if _, ok := t.rotators[id]; !ok {
+			added = append(added, id)
+		}
+	}
+	t.mu.RUnlock()
+
+	rotators := make(map[string]*Rotator, len(added))
+	for _, id := range added {
+		rotator := NewRotator(t.log, New(), t.store(id), t.config, id)
+		err := rotator.Init(ctx)
+		if err != nil {
+			return fmt.Errorf("tenant %q: %w", id, err)
+		}
+		rotators[id] = rotator
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	for id, rotator := range rotators {
+		t.rotators[id] = rotator
+	}
+	for id := range t.rotators {
+		if !contains(ids, id) {
+			delete(t.rotators, id)
+		}
+	}
+
+	return nil
+}
+
+// Run adds keyrings of new tenants on config changes, reloads keys and
+// rotates them on schedule until ctx is done.
+func (t *Tenants) Run(ctx context.Context) error {
+	ticker := time.NewTicker(RELOAD_Interval)
+	defer ticker.Stop()
+
+	changed := make(chan struct{}, 1)
+	unsubscribe := t.config.Subscribe(func(*config.AppConfig[time.Duration]) {
+		select {
+		case changed <- struct{}{}:
+		default:
+		}
+	})
+	defer unsubscribe()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-changed:
+			t.init(ctx)
+			continue
+		case <-ticker.C:
+		}
+
+		t.init(ctx)
+		for _, rotator := range t.all() {
+			rotator.reload(ctx)
+		}
+	}
+}
+
+func (t *Tenants) init(ctx context.Context) {
+	err := t.Init(ctx)
+	if err != nil {
+		t.log.Error("keyring: ", err)
+	}
+}
+
+func (t *Tenants) all() []*Rotator {
+	t.mu.RLock()
+	defer t.mu.RUnlock()
+
+	rotators := make([]*Rotator, 0, len(t.rotators))
+	for _, rotator := range t.rotators {
+		rotators = append(rotators, rotator)
+	}
+	return rotators
+}
+
+func contains(ids []string, id string) bool {
+	for _, v := range ids {
+		if v == id {
+			return true
+		}
+	}
+	return false
+}
@@ -3,6 +3,7 @@ package logger
 import (
 	"context"
 	"os"
+	"sync/atomic"
 
 	"github.com/sirupsen/logrus"
 )
@@ -11,10 +12,15 @@ type ContextKey string
 
 const (
 	CtxRequestId ContextKey = "request_id"
+	// CtxCaller is a name of authorized caller
+	CtxCaller ContextKey = "caller"
 )
 
 type Logger struct {
 	logrus.Logger
+
+	// claims are keys of redacted user claims
+	claims atomic.Value
 }
 
 func New() *Logger {
@@ -22,14 +28,19 @@ func New() *Logger {
 	log.Formatter = new(logrus.JSONFormatter)
 	log.Level = logrus.TraceLevel
 	log.Out = os.Stdout
+	log.Hooks = make(logrus.LevelHooks)
 
 	return &log
 }
 
 func (l *Logger) WithRequestInfo(ctx context.Context) *logrus.Entry {
 	requestId := ctx.Value(CtxRequestId)
-
-	return l.WithFields(logrus.Fields{
+	fields := logrus.Fields{
 		"id": requestId,
-	})
+	}
+	if caller, ok := ctx.Value(CtxCaller).(string); ok {
+		fields["caller"] = caller
+	}
+
+	return l.WithFields(fields)
 }
@@ -0,0 +1,121 @@
+package logger
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/protobuf/proto"
+	"google.golang.org/protobuf/reflect/protoreflect"
+)
+
+const (
+	REDACTED = "[REDACTED]"
+	// REDACT_AllClaims redacts values of every user claim
+	REDACT_AllClaims = "*"
+
+	// MAX_JtiLength limits length of jti logged from not verified token
+	MAX_JtiLength = 64
+)
+
+// TOKEN_Fields are string fields of messages with tokens. Tokens are logged
+// by their jti or by truncated SHA-256.
+var TOKEN_Fields = map[protoreflect.Name]bool{
+	"AccessToken":  true,
+	"RefreshToken": true,
+	"Token":        true,
+}
+
+// CLAIMS_Fields are map fields of messages with user claims. Values of
+// redacted claim keys are replaced by REDACTED.
+var CLAIMS_Fields = map[protoreflect.Name]bool{
+	"UserClaims": true,
+}
+
+// SetRedactedClaims sets keys of user claims which are not logged.
+// REDACT_AllClaims redacts every claim, empty keys log claims as is.
+func (l *Logger) SetRedactedClaims(keys []string) {
+	claims := make(map[string]bool, len(keys))
+	for _, key := range keys {
+		claims[key] = true
+	}
+	l.claims.Store(claims)
+}
+
+// Redact returns copy of proto message without raw tokens and values of
+// redacted claims. Other values are returned as is.
+func (l *Logger) Redact(v any) any {
+	msg, ok := v.(proto.Message)
+	if !ok || msg == nil || !msg.ProtoReflect().IsValid() {
+		return v
+	}
+
+	claims, ok := l.claims.Load().(map[string]bool)
+	if !ok {
+		claims = map[string]bool{REDACT_AllClaims: true}
+	}
+
+	clone := proto.Clone(msg)
+	redact(clone.ProtoReflect(), claims)
+	return clone
+}
+
+// TokenFingerprint identifies token in logs without the token itself. Token
+// is not verified, so jti is only a hint.
+func TokenFingerprint(token string) string {
+	if token == "" {
+		return ""
+	}
+
+	sum := sha256.Sum256([]byte(token))
+	fingerprint := "sha256:" + hex.EncodeToString(sum[:])[:12]
+
+	var claims jwt.RegisteredClaims
+	_, _, err := jwt.NewParser().ParseUnverified(token, &claims)
+	if err == nil && claims.ID != "" && len(claims.ID) <= MAX_JtiLength {
+		return "jti:" + claims.ID + " " + fingerprint
+	}
+	return fingerprint
+}
+
+func redact(m protoreflect.Message, claims map[string]bool) {
+	var fields []protoreflect.FieldDescriptor
+	m.Range(func(fd protoreflect.FieldDescriptor, _ protoreflect.Value) bool {
+		fields = append(fields, fd)
+		return true
+	})
+
+	for _, fd := range fields {
+		switch {
+		case fd.IsMap():
+			if CLAIMS_Fields[fd.Name()] && fd.MapValue().Kind() == protoreflect.StringKind {
+				redactClaims(m.Mutable(fd).Map(), claims)
+			}
+		case fd.IsList():
+			if fd.Kind() == protoreflect.MessageKind {
+				list := m.Mutable(fd).List()
+				for i := 0; i < list.Len(); i++ {
+					redact(list.Get(i).Message(), claims)
+				}
+			}
+		case fd.Kind() == protoreflect.MessageKind:
+			redact(m.Mutable(fd).Message(), claims)
+		case fd.Kind() == protoreflect.StringKind && TOKEN_Fields[fd.Name()]:
+			m.Set(fd, protoreflect.ValueOfString(TokenFingerprint(m.Get(fd).String())))
+		}
+	}
+}
+
+func redactClaims(values protoreflect.Map, claims map[string]bool) {
+	var keys []protoreflect.MapKey
+	values.Range(func(key protoreflect.MapKey, _ protoreflect.Value) bool {
+		if claims[REDACT_AllClaims] || claims[key.String()] {
+			keys = append(keys, key)
+		}
+		return true
+	})
+
+	for _, key := range keys {
+		values.Set(key, protoreflect.ValueOfString(REDACTED))
+	}
+}
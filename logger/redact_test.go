@@ -0,0 +1,98 @@
+package logger
+
+import (
+	"encoding/json"
+	"strings"
+	"testing"
+
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/protobuf/proto"
+)
+
+func TestRedact(t *testing.T) {
+	token, err := jwt.NewWithClaims(jwt.SigningMethodHS256, jwt.RegisteredClaims{ID: "access-uuid"}).SignedString([]byte("secret"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		claims   []string
+		req      proto.Message
+		hidden   []string
+		expected []string
+	}{
+		{
+			name:     "all claims",
+			req:      &jwt_gRPC.CreateTokensRequest{UserId: "123", UserClaims: map[string]string{"email": "user@example.com", "role": "admin"}},
+			hidden:   []string{"user@example.com", "admin"},
+			expected: []string{"123", REDACTED},
+		},
+		{
+			name:     "claim keys",
+			claims:   []string{"email"},
+			req:      &jwt_gRPC.CreateTokensRequest{UserId: "123", UserClaims: map[string]string{"email": "user@example.com", "role": "admin"}},
+			hidden:   []string{"user@example.com"},
+			expected: []string{"admin", REDACTED},
+		},
+		{
+			name:     "token with jti",
+			req:      &jwt_gRPC.GetUserIdRequest{AccessToken: token},
+			hidden:   []string{token},
+			expected: []string{"jti:access-uuid", "sha256:"},
+		},
+		{
+			name:     "not valid token",
+			req:      &jwt_gRPC.IntrospectTokenRequest{Token: "opaque-token"},
+			hidden:   []string{"opaque-token"},
+			expected: []string{"sha256:"},
+		},
+		{
+			name:     "optional tokens",
+			req:      &jwt_gRPC.CheckTokenExistenceRequest{AccessToken: proto.String(token), RefreshToken: proto.String("refresh-token")},
+			hidden:   []string{token, "refresh-token"},
+			expected: []string{"jti:access-uuid"},
+		},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			log := New()
+			if test.claims != nil {
+				log.SetRedactedClaims(test.claims)
+			}
+
+			original := proto.Clone(test.req)
+			b, err := json.Marshal(log.Redact(test.req))
+			if err != nil {
+				t.Fatal(err)
+			}
+			for _, hidden := range test.hidden {
+				if strings.Contains(string(b), hidden) {
+					t.Errorf("not redacted %q in %s", hidden, b)
+				}
+			}
+			for _, expected := range test.expected {
+				if !strings.Contains(string(b), expected) {
+					t.Errorf("not found %q in %s", expected, b)
+				}
+			}
+			if !proto.Equal(test.req, original) {
+				t.Errorf("request is changed %v, expected %v", test.req, original)
+			}
+		})
+	}
+}
+
+func TestRedactNotProto(t *testing.T) {
+	log := New()
+	var req *jwt_gRPC.GetUserIdRequest
+
+	if v := log.Redact("value"); v != "value" {
+		t.Errorf("not valid value %v, expected %q", v, "value")
+	}
+	if v := log.Redact(req); v != req {
+		t.Errorf("not valid value %v, expected nil request", v)
+	}
+}
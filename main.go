@@ -2,17 +2,20 @@ package main
 
 import (
 	"context"
+	"crypto/tls"
 	"fmt"
 	"os"
 	"os/signal"
 	"syscall"
 
-	"github.com/Moranilt/jwt-http2/certs"
 	"github.com/Moranilt/jwt-http2/clients"
 	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/keyring"
 	"github.com/Moranilt/jwt-http2/logger"
 	"github.com/Moranilt/jwt-http2/middleware"
 	"github.com/Moranilt/jwt-http2/server"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/Moranilt/jwt-http2/tlscert"
 	"github.com/Moranilt/jwt-http2/tracer"
 	grpc_transport "github.com/Moranilt/jwt-http2/transport/grpc"
 	http_transport "github.com/Moranilt/jwt-http2/transport/http"
@@ -34,67 +37,115 @@ func main() {
 	if err != nil {
 		log.Fatalf("error while reading env: %v", err)
 	}
+	log.SetRedactedClaims(env.RedactClaims)
 
-	tp, err := tracer.NewProvider(env.Jaeger.URL, env.Jaeger.Name)
-	if err != nil {
-		log.Fatal("jaeger: ", err)
-	}
-
-	defer func(ctx context.Context) {
-		if err := tp.Shutdown(ctx); err != nil {
-			log.Printf("shutting down tracer provider: %v", err)
+	if env.Jaeger != nil {
+		tp, err := tracer.NewProvider(env.Jaeger.URL, env.Jaeger.Name)
+		if err != nil {
+			log.Fatal("jaeger: ", err)
 		}
-	}(ctx)
 
-	vaultClient, err := clients.Vault(env.Vault)
-	if err != nil {
-		log.Fatalf("vault client: %v", err)
+		defer func(ctx context.Context) {
+			if err := tp.Shutdown(ctx); err != nil {
+				log.Printf("shutting down tracer provider: %v", err)
+			}
+		}(ctx)
 	}
 
-	// use only in local or dev modes
-	if !env.Production {
-		certGenerator := certs.NewKeys(vaultClient.GetClient(), env.Vault)
-		err := certGenerator.StoreToVault()
+	var vaultClient *clients.VaultClient
+	var redisCreds *clients.RedisCreds
+	if env.Vault != nil {
+		vaultClient, err = clients.Vault(env.Vault)
 		if err != nil {
-			log.Fatalf("create certificates: %v", err)
+			log.Fatalf("vault client: %v", err)
 		}
+
+		redisCreds, err = vaultClient.GetRedisCreds(ctx)
+		if err != nil {
+			log.Fatalf("vault client: %v", err)
+		}
+	} else if env.Redis != nil {
+		redisCreds = clients.RedisCredsFromEnv(env.Redis)
 	}
 
-	redisCreds, err := vaultClient.GetRedisCreds(ctx)
+	source, err := configSource(ctx, log, env)
 	if err != nil {
-		log.Fatalf("vault client: %v", err)
+		log.Fatalf("config source: %v", err)
 	}
 
-	publicCert, err := vaultClient.GetPublicCert(ctx)
+	mainConfig := config.New(log)
+	err = mainConfig.Load(ctx, source)
 	if err != nil {
-		log.Fatalf("vault public cert: %v", err)
+		log.Fatalf("read config from %s: %v", env.Source, err)
 	}
 
-	privateCert, err := vaultClient.GetPrivateCert(ctx)
-	if err != nil {
-		log.Fatalf("vault private cert: %v", err)
+	var store storage.SessionStore
+	var memoryStore *storage.Memory
+	schema := storage.NewKeySchema("")
+	if redisCreds != nil {
+		redis, err := clients.Redis(ctx, redisCreds)
+		if err != nil {
+			log.Fatalf("redis client: %v", err)
+		}
+		store = storage.NewRedis(redis)
+		schema = storage.NewKeySchema(redisCreds.KeyPrefix())
+	} else {
+		log.Warn("redis is not set, sessions are stored in memory")
+		memoryStore = storage.NewMemory()
+		store = memoryStore
 	}
 
-	redis, err := clients.Redis(ctx, redisCreds)
-	if err != nil {
-		log.Fatalf("redis client: %v", err)
+	var tenantKeys func(tenant string) keyring.Store
+	if vaultClient != nil {
+		tenantKeys = vaultClient.TenantKeys
+	} else {
+		log.Warn("vault is not set, signing keys are stored in memory")
+		tenantKeys = func(string) keyring.Store {
+			return keyring.NewMemoryStore()
+		}
 	}
 
-	consulClient, err := clients.Consul(ctx, env.Consul)
+	// keys of tenants are generated if they are not stored yet
+	keys := keyring.NewTenants(log, mainConfig, tenantKeys)
+	err = keys.Init(ctx)
 	if err != nil {
-		log.Fatalf("consul client: %v", err)
+		log.Fatalf("load keys: %v", err)
 	}
 
-	mainConfig := config.New(log)
-	err = mainConfig.ReadConsul(ctx, env.Consul.Key(), consulClient)
+	if mainConfig.Current().Auth == nil {
+		log.Warn("authorization of callers is off: auth is not configured")
+	}
+	mw := middleware.New(log, mainConfig)
+	server, err := server.New(log, mainConfig, store, schema, keys)
 	if err != nil {
-		log.Fatal("read from consul: ", err)
+		log.Fatal("server: ", err)
+	}
+	serverREST := http_transport.New(fmt.Sprintf(":%s", env.PortREST), log, mainConfig, env.Consul, server, keys, mw)
+
+	var tlsConfig *tls.Config
+	var tlsReloader *tlscert.Reloader
+	if env.TLS != nil {
+		var tlsSource tlscert.Source = &tlscert.FileSource{
+			Cert:     env.TLS.CertFile,
+			Key:      env.TLS.KeyFile,
+			ClientCA: env.TLS.ClientCAFile,
+		}
+		if env.TLS.VaultPath != "" {
+			tlsSource = vaultClient.TLSCerts(env.TLS.VaultPath)
+		}
+
+		tlsReloader, err = tlscert.NewReloader(log, tlsSource, env.TLS.ClientAuth)
+		if err != nil {
+			log.Fatal("grpc tls: ", err)
+		}
+		err = tlsReloader.Reload(ctx)
+		if err != nil {
+			log.Fatal("grpc tls: ", err)
+		}
+		tlsConfig = tlsReloader.ServerConfig()
 	}
 
-	serverREST := http_transport.New(fmt.Sprintf(":%s", env.PortREST), log, mainConfig, env.Consul.Key())
-	mw := middleware.New(log)
-	server := server.New(log, mainConfig.App, redis, publicCert, privateCert)
-	serverGRPC := grpc_transport.New(server, mw)
+	serverGRPC := grpc_transport.New(server, mw, tlsConfig)
 	lis, err := serverGRPC.MakeListener(env.PortGRPC)
 	if err != nil {
 		log.Fatal(err)
@@ -117,7 +168,46 @@ func main() {
 		return serverREST.ListenAndServe()
 	})
 
+	g.Go(func() error {
+		return keys.Run(gCtx)
+	})
+
+	if tlsReloader != nil {
+		g.Go(func() error {
+			return tlsReloader.Run(gCtx, env.TLS.ReloadInterval)
+		})
+	}
+
+	if memoryStore != nil {
+		g.Go(func() error {
+			return memoryStore.Run(gCtx, storage.MEMORY_EvictionInterval)
+		})
+	}
+
+	// in webhook mode config is sent to /watch by Consul
+	if env.Consul == nil || env.Consul.WatchMode == config.WATCH_ModeBlocking {
+		g.Go(func() error {
+			return mainConfig.Watch(gCtx, source)
+		})
+	}
+
 	if err := g.Wait(); err != nil {
 		log.Debugf("exit with: %s", err)
 	}
 }
+
+// configSource makes source of application config selected by CONFIG_SOURCE.
+func configSource(ctx context.Context, log *logger.Logger, env *config.Env) (config.Source, error) {
+	switch env.Source {
+	case config.SOURCE_File:
+		return config.NewFileSource(log, env.File), nil
+	case config.SOURCE_Env:
+		return config.NewEnvSource(), nil
+	default:
+		consulClient, err := clients.Consul(ctx, env.Consul)
+		if err != nil {
+			return nil, err
+		}
+		return config.NewConsulSource(log, consulClient, env.Consul.Key()), nil
+	}
+}
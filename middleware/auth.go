@@ -0,0 +1,112 @@
+package middleware
+
+import (
+	"context"
+	"crypto/x509"
+	"strings"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// HEADER_APIKey is a header and gRPC metadata with API key of caller.
+	HEADER_APIKey = "x-api-key"
+
+	ERROR_NotIdentifiedCaller = "caller is not identified: provide API key or client certificate"
+	ERROR_UnknownAPIKey       = "unknown API key"
+	ERROR_UnknownCertificate  = "unknown client certificate %q"
+	ERROR_CallerMismatch      = "API key of caller %q and certificate of caller %q don't match"
+	ERROR_NotAllowedCaller    = "caller %q is not allowed to call %s"
+	ERROR_AuthNotConfigured   = "%s requires auth config"
+)
+
+// FullMethod returns full gRPC method of Authentication RPC.
+func FullMethod(rpc string) string {
+	return "/" + jwt_gRPC.Authentication_ServiceDesc.ServiceName + "/" + rpc
+}
+
+// AuthInterceptor authorizes callers of Authentication RPCs by API key in
+// metadata or by verified client certificate.
+func (m *Middleware) AuthInterceptor(ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (any, error) {
+	var apiKey string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if keys := md.Get(HEADER_APIKey); len(keys) > 0 {
+			apiKey = keys[0]
+		}
+	}
+
+	var cert *x509.Certificate
+	if p, ok := peer.FromContext(ctx); ok {
+		if tlsInfo, ok := p.AuthInfo.(credentials.TLSInfo); ok && len(tlsInfo.State.VerifiedChains) > 0 {
+			cert = tlsInfo.State.VerifiedChains[0][0]
+		}
+	}
+
+	caller, err := m.Authorize(info.FullMethod, apiKey, cert)
+	if err != nil {
+		return nil, err
+	}
+	if caller != "" {
+		ctx = context.WithValue(ctx, logger.CtxCaller, caller)
+	}
+
+	return handler(ctx, req)
+}
+
+// Authorize returns name of caller allowed to call method. Methods of other
+// services and every method without auth config are allowed to anyone, except
+// keys rotation which is denied without auth config. Errors are gRPC statuses.
+func (m *Middleware) Authorize(fullMethod string, apiKey string, cert *x509.Certificate) (string, error) {
+	service, rpc, ok := strings.Cut(strings.TrimPrefix(fullMethod, "/"), "/")
+	if !ok || service != jwt_gRPC.Authentication_ServiceDesc.ServiceName {
+		return "", nil
+	}
+
+	auth := m.config.Current().Auth
+	if auth == nil {
+		if config.AdminRule(rpc) {
+			return "", status.Errorf(codes.Unauthenticated, ERROR_AuthNotConfigured, rpc)
+		}
+		return "", nil
+	}
+
+	var keyCaller, certCaller string
+	if apiKey != "" {
+		keyCaller, ok = auth.CallerByAPIKey(apiKey)
+		if !ok {
+			return "", status.Error(codes.Unauthenticated, ERROR_UnknownAPIKey)
+		}
+	}
+	if cert != nil {
+		certCaller, ok = auth.CallerByCertificate(cert)
+		if !ok && keyCaller == "" {
+			return "", status.Errorf(codes.Unauthenticated, ERROR_UnknownCertificate, cert.Subject.CommonName)
+		}
+	}
+
+	caller := keyCaller
+	if caller == "" {
+		caller = certCaller
+	} else if certCaller != "" && certCaller != caller {
+		return "", status.Errorf(codes.Unauthenticated, ERROR_CallerMismatch, keyCaller, certCaller)
+	}
+	if caller == "" {
+		return "", status.Error(codes.Unauthenticated, ERROR_NotIdentifiedCaller)
+	}
+
+	if !auth.Allowed(rpc, caller) {
+		return caller, status.Errorf(codes.PermissionDenied, ERROR_NotAllowedCaller, caller, rpc)
+	}
+	return caller, nil
+}
@@ -0,0 +1,133 @@
+package middleware
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/base64"
+	"io"
+	"net/url"
+	"testing"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	testConsulKey = "auth/v1.0.0/config.yaml"
+	testConfig    = "issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 15m\n  refresh: 1d\n"
+)
+
+func newTestMiddleware(tb testing.TB, yaml string) *Middleware {
+	tb.Helper()
+
+	log := logger.New()
+	log.Out = io.Discard
+	cfg := config.New(log)
+	err := cfg.WatchConsul(context.Background(), testConsulKey, []config.WatchConsulBody{
+		{Key: testConsulKey, Value: base64.StdEncoding.EncodeToString([]byte(yaml))},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return New(log, cfg)
+}
+
+func TestAuthorize(t *testing.T) {
+	mw := newTestMiddleware(t, testConfig+"auth:\n"+
+		"  callers:\n"+
+		"    login:\n      api_keys: ["+config.HashAPIKey("login-key")+"]\n      certificates: [login.internal]\n"+
+		"    orders:\n      certificates: [spiffe://cluster/orders]\n"+
+		"  rules:\n    CreateTokens: [login]\n    \"*\": [\"*\"]\n")
+
+	login := &x509.Certificate{Subject: pkix.Name{CommonName: "login.internal"}}
+	uri, err := url.Parse("spiffe://cluster/orders")
+	if err != nil {
+		t.Fatal(err)
+	}
+	orders := &x509.Certificate{Subject: pkix.Name{CommonName: "orders"}, URIs: []*url.URL{uri}}
+	unknown := &x509.Certificate{Subject: pkix.Name{CommonName: "unknown"}}
+
+	tests := []struct {
+		name   string
+		method string
+		apiKey string
+		cert   *x509.Certificate
+		caller string
+		code   codes.Code
+	}{
+		{name: "api key", method: FullMethod("CreateTokens"), apiKey: "login-key", caller: "login"},
+		{name: "certificate", method: FullMethod("CreateTokens"), cert: login, caller: "login"},
+		{name: "api key and certificate", method: FullMethod("CreateTokens"), apiKey: "login-key", cert: login, caller: "login"},
+		{name: "uri of certificate", method: FullMethod("GetUserId"), cert: orders, caller: "orders"},
+		{name: "not allowed caller", method: FullMethod("CreateTokens"), cert: orders, code: codes.PermissionDenied},
+		{name: "unknown api key", method: FullMethod("GetUserId"), apiKey: "other-key", code: codes.Unauthenticated},
+		{name: "unknown certificate", method: FullMethod("GetUserId"), cert: unknown, code: codes.Unauthenticated},
+		{name: "api key and certificate of other callers", method: FullMethod("GetUserId"), apiKey: "login-key", cert: orders, code: codes.Unauthenticated},
+		{name: "not identified caller", method: FullMethod("GetUserId"), code: codes.Unauthenticated},
+		{name: "health service", method: "/grpc.health.v1.Health/Check"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			caller, err := mw.Authorize(test.method, test.apiKey, test.cert)
+			if code := status.Code(err); code != test.code {
+				t.Fatalf("not valid code %v, expected %v: %v", code, test.code, err)
+			}
+			if err == nil && caller != test.caller {
+				t.Errorf("not valid caller %q, expected %q", caller, test.caller)
+			}
+		})
+	}
+}
+
+func TestAuthorizeWithoutAuth(t *testing.T) {
+	mw := newTestMiddleware(t, testConfig)
+
+	caller, err := mw.Authorize(FullMethod("CreateTokens"), "", nil)
+	if err != nil || caller != "" {
+		t.Errorf("not valid result %q, %v, expected anonymous caller", caller, err)
+	}
+}
+
+func TestAuthInterceptor(t *testing.T) {
+	mw := newTestMiddleware(t, testConfig+"auth:\n"+
+		"  callers:\n"+
+		"    login:\n      api_keys: ["+config.HashAPIKey("login-key")+"]\n"+
+		"    orders:\n      certificates: [orders.internal]\n"+
+		"  rules:\n    CreateTokens: [login, orders]\n")
+
+	var caller any
+	handler := func(ctx context.Context, req any) (any, error) {
+		caller = ctx.Value(logger.CtxCaller)
+		return nil, nil
+	}
+	info := &grpc.UnaryServerInfo{FullMethod: FullMethod("CreateTokens")}
+
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(HEADER_APIKey, "login-key"))
+	_, err := mw.AuthInterceptor(ctx, nil, info, handler)
+	if err != nil || caller != "login" {
+		t.Errorf("not valid caller of api key %v: %v", caller, err)
+	}
+
+	cert := &x509.Certificate{Subject: pkix.Name{CommonName: "orders.internal"}}
+	ctx = peer.NewContext(context.Background(), &peer.Peer{
+		AuthInfo: credentials.TLSInfo{State: tls.ConnectionState{VerifiedChains: [][]*x509.Certificate{{cert}}}},
+	})
+	_, err = mw.AuthInterceptor(ctx, nil, info, handler)
+	if err != nil || caller != "orders" {
+		t.Errorf("not valid caller of certificate %v: %v", caller, err)
+	}
+
+	_, err = mw.AuthInterceptor(context.Background(), nil, &grpc.UnaryServerInfo{FullMethod: FullMethod("GetUserId")}, handler)
+	if status.Code(err) != codes.Unauthenticated {
+		t.Errorf("not valid error %v, expected %v", err, codes.Unauthenticated)
+	}
+}
@@ -2,21 +2,34 @@ package middleware
 
 import (
 	"context"
+	"net/http"
 	"time"
 
+	"github.com/Moranilt/jwt-http2/config"
 	"github.com/Moranilt/jwt-http2/logger"
 	"github.com/google/uuid"
 	"github.com/sirupsen/logrus"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/metadata"
+)
+
+const (
+	// HEADER_RequestId is a header and gRPC metadata with request id. Id of
+	// caller is used if it is valid, otherwise new id is generated.
+	HEADER_RequestId = "x-request-id"
+
+	MAX_RequestIdLength = 128
 )
 
 type Middleware struct {
-	log *logger.Logger
+	log    *logger.Logger
+	config config.Provider
 }
 
-func New(log *logger.Logger) *Middleware {
+func New(log *logger.Logger, config config.Provider) *Middleware {
 	return &Middleware{
-		log: log,
+		log:    log,
+		config: config,
 	}
 }
 
@@ -24,9 +37,16 @@ func (m *Middleware) UnaryInterceptor(ctx context.Context,
 	req any,
 	info *grpc.UnaryServerInfo,
 	handler grpc.UnaryHandler) (any, error) {
-	reqID := uuid.NewString()
+	var reqID string
+	if md, ok := metadata.FromIncomingContext(ctx); ok {
+		if ids := md.Get(HEADER_RequestId); len(ids) > 0 {
+			reqID = ids[0]
+		}
+	}
+	reqID = requestID(reqID)
 	start := time.Now()
 	newCtx := context.WithValue(ctx, logger.CtxRequestId, reqID)
+	grpc.SetHeader(ctx, metadata.Pairs(HEADER_RequestId, reqID))
 
 	h, err := handler(newCtx, req)
 
@@ -35,17 +55,67 @@ func (m *Middleware) UnaryInterceptor(ctx context.Context,
 			"method":   info.FullMethod,
 			"duration": time.Since(start),
 			"error":    err.Error(),
-			"req":      req,
+			"req":      m.log.Redact(req),
 			"id":       reqID,
 		}).Error()
 	} else {
 		m.log.WithFields(logrus.Fields{
 			"method":   info.FullMethod,
 			"duration": time.Since(start),
-			"req":      req,
+			"req":      m.log.Redact(req),
 			"id":       reqID,
 		}).Info()
 	}
 
 	return h, err
 }
+
+// HTTP sets request id of HTTP request and logs it as UnaryInterceptor does.
+func (m *Middleware) HTTP(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		reqID := requestID(r.Header.Get(HEADER_RequestId))
+		start := time.Now()
+		newCtx := context.WithValue(r.Context(), logger.CtxRequestId, reqID)
+		w.Header().Set(HEADER_RequestId, reqID)
+
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		next.ServeHTTP(sw, r.WithContext(newCtx))
+
+		entry := m.log.WithFields(logrus.Fields{
+			"method":   r.Method,
+			"path":     r.URL.Path,
+			"status":   sw.status,
+			"duration": time.Since(start),
+			"id":       reqID,
+		})
+		if sw.status >= http.StatusInternalServerError {
+			entry.Error()
+		} else {
+			entry.Info()
+		}
+	})
+}
+
+// requestID returns id of caller if it is printable and not too long.
+func requestID(id string) string {
+	if id == "" || len(id) > MAX_RequestIdLength {
+		return uuid.NewString()
+	}
+	for _, r := range id {
+		if r < '!' || r > '~' {
+			return uuid.NewString()
+		}
+	}
+	return id
+}
+
+// statusWriter remembers status of response.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
@@ -0,0 +1,40 @@
+package middleware
+
+import (
+	"context"
+	"strings"
+	"testing"
+
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc"
+)
+
+func TestUnaryInterceptorRedactsRequest(t *testing.T) {
+	mw := newTestMiddleware(t, testConfig)
+	hook := test.NewLocal(&mw.log.Logger)
+
+	req := &jwt_gRPC.CreateTokensRequest{UserId: "1", UserClaims: map[string]string{"email": "user@example.com"}}
+	info := &grpc.UnaryServerInfo{FullMethod: FullMethod("CreateTokens")}
+	_, err := mw.UnaryInterceptor(context.Background(), req, info, func(ctx context.Context, req any) (any, error) {
+		return &jwt_gRPC.CreateTokensResponse{}, nil
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	entry := hook.LastEntry()
+	if entry == nil {
+		t.Fatal("request is not logged")
+	}
+	line, err := entry.String()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(line, "user@example.com") {
+		t.Errorf("claim is logged: %s", line)
+	}
+	if req.UserClaims["email"] != "user@example.com" {
+		t.Errorf("request is changed %v", req)
+	}
+}
@@ -0,0 +1,139 @@
+package server
+
+import (
+	"context"
+	"errors"
+
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// ERROR_Domain is a domain of ErrorInfo attached to statuses of RPCs.
+const ERROR_Domain = "jwt-grpc"
+
+// Reasons of ErrorInfo. They are stable, so clients may rely on them instead
+// of messages. Client should refresh tokens on REASON_TokenExpired and log the
+// user out on REASON_TokenRevoked and REASON_TokenReused.
+const (
+	REASON_InvalidArgument    = "INVALID_ARGUMENT"
+	REASON_UnknownTenant      = "UNKNOWN_TENANT"
+	REASON_TenantMismatch     = "TENANT_MISMATCH"
+	REASON_TokenMalformed     = "TOKEN_MALFORMED"
+	REASON_TokenExpired       = "TOKEN_EXPIRED"
+	REASON_TokenNotYetValid   = "TOKEN_NOT_YET_VALID"
+	REASON_TokenRevoked       = "TOKEN_REVOKED"
+	REASON_TokenReused        = "TOKEN_REUSED"
+	REASON_SignatureInvalid   = "SIGNATURE_INVALID"
+	REASON_UnknownKey         = "UNKNOWN_KEY"
+	REASON_AudienceMismatch   = "AUDIENCE_MISMATCH"
+	REASON_IssuerMismatch     = "ISSUER_MISMATCH"
+	REASON_SubjectMismatch    = "SUBJECT_MISMATCH"
+	REASON_ClaimsInvalid      = "CLAIMS_INVALID"
+	REASON_StorageUnavailable = "STORAGE_UNAVAILABLE"
+	REASON_Internal           = "INTERNAL"
+
+	ERROR_Internal = "internal error"
+)
+
+var (
+	ErrProvideAnyField      = errors.New(ERROR_ProvideAnyField)
+	ErrProvideUserId        = errors.New(ERROR_ProvideUserId)
+	ErrProvideToken         = errors.New(ERROR_ProvideToken)
+	ErrTokenNotFound        = errors.New(ERROR_TokenNotFound)
+	ErrRefreshTokenNotFound = errors.New(ERROR_RefreshTokenNotFound)
+	ErrRefreshTokenReused   = errors.New(ERROR_RefreshTokenReused)
+	// ErrUnknownKey is returned for token signed by key which is not in keyring.
+	ErrUnknownKey = errors.New("unknown key id")
+	// ErrInvalidClaims is returned for token with claims of other kind.
+	ErrInvalidClaims = errors.New("not valid token claims")
+)
+
+// errorKind is a code and reason of errors matching err.
+type errorKind struct {
+	err    error
+	code   codes.Code
+	reason string
+}
+
+// errorKinds are checked in order, so specific errors of jwt go before
+// errors wrapping them.
+var errorKinds = []errorKind{
+	{err: ErrProvideAnyField, code: codes.InvalidArgument, reason: REASON_InvalidArgument},
+	{err: ErrProvideUserId, code: codes.InvalidArgument, reason: REASON_InvalidArgument},
+	{err: ErrProvideToken, code: codes.InvalidArgument, reason: REASON_InvalidArgument},
+	{err: ErrUnknownTenant, code: codes.NotFound, reason: REASON_UnknownTenant},
+	{err: ErrTenantMismatch, code: codes.Unauthenticated, reason: REASON_TenantMismatch},
+	{err: ErrTokenNotFound, code: codes.Unauthenticated, reason: REASON_TokenRevoked},
+	{err: ErrRefreshTokenNotFound, code: codes.Unauthenticated, reason: REASON_TokenRevoked},
+	{err: ErrRefreshTokenReused, code: codes.Unauthenticated, reason: REASON_TokenReused},
+	{err: ErrUnknownKey, code: codes.Unauthenticated, reason: REASON_UnknownKey},
+	{err: jwt.ErrTokenExpired, code: codes.Unauthenticated, reason: REASON_TokenExpired},
+	{err: jwt.ErrTokenNotValidYet, code: codes.Unauthenticated, reason: REASON_TokenNotYetValid},
+	{err: jwt.ErrTokenUsedBeforeIssued, code: codes.Unauthenticated, reason: REASON_TokenNotYetValid},
+	{err: jwt.ErrTokenInvalidAudience, code: codes.Unauthenticated, reason: REASON_AudienceMismatch},
+	{err: jwt.ErrTokenInvalidIssuer, code: codes.Unauthenticated, reason: REASON_IssuerMismatch},
+	{err: jwt.ErrTokenInvalidSubject, code: codes.Unauthenticated, reason: REASON_SubjectMismatch},
+	{err: jwt.ErrTokenSignatureInvalid, code: codes.Unauthenticated, reason: REASON_SignatureInvalid},
+	{err: jwt.ErrTokenMalformed, code: codes.Unauthenticated, reason: REASON_TokenMalformed},
+	{err: jwt.ErrTokenUnverifiable, code: codes.Unauthenticated, reason: REASON_TokenMalformed},
+	{err: jwt.ErrTokenRequiredClaimMissing, code: codes.Unauthenticated, reason: REASON_ClaimsInvalid},
+	{err: jwt.ErrTokenInvalidId, code: codes.Unauthenticated, reason: REASON_ClaimsInvalid},
+	{err: jwt.ErrTokenInvalidClaims, code: codes.Unauthenticated, reason: REASON_ClaimsInvalid},
+	{err: ErrInvalidClaims, code: codes.Unauthenticated, reason: REASON_ClaimsInvalid},
+}
+
+// Status returns gRPC status of error with ErrorInfo describing its reason.
+// Statuses are returned as is. Messages of unavailable storage and internal
+// errors are not returned, they are logged by RPCs.
+func Status(err error) *status.Status {
+	if err == nil {
+		return nil
+	}
+	if st, ok := status.FromError(err); ok {
+		return st
+	}
+	if errors.Is(err, context.Canceled) {
+		return status.New(codes.Canceled, err.Error())
+	}
+	if errors.Is(err, context.DeadlineExceeded) {
+		return status.New(codes.DeadlineExceeded, err.Error())
+	}
+
+	for _, kind := range errorKinds {
+		if errors.Is(err, kind.err) {
+			return withReason(kind.code, err.Error(), kind.reason)
+		}
+	}
+	if errors.Is(err, storage.ErrUnavailable) {
+		return withReason(codes.Unavailable, storage.ErrUnavailable.Error(), REASON_StorageUnavailable)
+	}
+	return withReason(codes.Internal, ERROR_Internal, REASON_Internal)
+}
+
+// StatusInterceptor replaces errors of RPCs by their statuses.
+func (s *Server) StatusInterceptor(ctx context.Context,
+	req any,
+	info *grpc.UnaryServerInfo,
+	handler grpc.UnaryHandler) (any, error) {
+	res, err := handler(ctx, req)
+	if err != nil {
+		return nil, Status(err).Err()
+	}
+	return res, nil
+}
+
+func withReason(code codes.Code, msg string, reason string) *status.Status {
+	st := status.New(code, msg)
+	detailed, err := st.WithDetails(&errdetails.ErrorInfo{
+		Reason: reason,
+		Domain: ERROR_Domain,
+	})
+	if err != nil {
+		return st
+	}
+	return detailed
+}
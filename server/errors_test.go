@@ -0,0 +1,162 @@
+package server
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+)
+
+// unavailableStore fails every read as Redis which is down.
+type unavailableStore struct {
+	storage.SessionStore
+}
+
+func (unavailableStore) Get(ctx context.Context, key string) (string, error) {
+	return "", fmt.Errorf("%w: connection refused", storage.ErrUnavailable)
+}
+
+func TestStatusInterceptor(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	t0 := s.mustTenant(t, config.DEFAULT_Tenant)
+
+	tokens := s.mustCreateTokens(t)
+	revoked := s.mustCreateTokens(t)
+	_, err := s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: revoked.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expired, err := s.makeAccessToken(ctx, t0, "uuid", nil, time.Now().Add(-time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	otherAudience := *t0
+	otherConfig := *t0.config
+	otherConfig.Audience = []string{"http://other.localhost"}
+	otherAudience.config = &otherConfig
+	audience, err := s.makeAccessToken(ctx, &otherAudience, "uuid", nil, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// payload of one token with signature of other
+	parts := strings.Split(tokens.AccessToken, ".")
+	signature := revoked.AccessToken[strings.LastIndex(revoked.AccessToken, ".")+1:]
+	tampered := parts[0] + "." + parts[1] + "." + signature
+
+	active := t0.keys.Active()
+	token := jwt.NewWithClaims(active.Method, jwt.MapClaims{"sub": "user"})
+	token.Header["kid"] = "unknown"
+	unknownKey, err := token.SignedString(active.Signer)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	unavailable := newTestServer(t)
+	unavailableTokens := unavailable.mustCreateTokens(t)
+	unavailable.store = unavailableStore{unavailable.store}
+
+	tests := []struct {
+		name   string
+		server *Server
+		token  string
+		code   codes.Code
+		reason string
+	}{
+		{name: "expired token", token: expired, code: codes.Unauthenticated, reason: REASON_TokenExpired},
+		{name: "revoked token", token: revoked.AccessToken, code: codes.Unauthenticated, reason: REASON_TokenRevoked},
+		{name: "not valid signature", token: tampered, code: codes.Unauthenticated, reason: REASON_SignatureInvalid},
+		{name: "other audience", token: audience, code: codes.Unauthenticated, reason: REASON_AudienceMismatch},
+		{name: "unknown key", token: unknownKey, code: codes.Unauthenticated, reason: REASON_UnknownKey},
+		{name: "malformed token", token: "token", code: codes.Unauthenticated, reason: REASON_TokenMalformed},
+		{name: "empty request", code: codes.Unauthenticated, reason: REASON_TokenMalformed},
+		{name: "storage is down", server: unavailable, token: unavailableTokens.AccessToken, code: codes.Unavailable, reason: REASON_StorageUnavailable},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			server := s
+			if test.server != nil {
+				server = test.server
+			}
+
+			_, err := server.StatusInterceptor(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: test.token}, &grpc.UnaryServerInfo{},
+				func(ctx context.Context, req any) (any, error) {
+					return server.GetUserId(ctx, req.(*jwt_gRPC.GetUserIdRequest))
+				})
+			assertStatus(t, err, test.code, test.reason)
+		})
+	}
+}
+
+func TestStatus(t *testing.T) {
+	tests := []struct {
+		name   string
+		err    error
+		code   codes.Code
+		reason string
+	}{
+		{name: "missing field", err: ErrProvideToken, code: codes.InvalidArgument, reason: REASON_InvalidArgument},
+		{name: "unknown tenant", err: fmt.Errorf("%w %q", ErrUnknownTenant, "shop"), code: codes.NotFound, reason: REASON_UnknownTenant},
+		{name: "reused refresh token", err: ErrRefreshTokenReused, code: codes.Unauthenticated, reason: REASON_TokenReused},
+		{name: "tenant mismatch", err: &tenantMismatchError{claimed: "shop", expected: config.DEFAULT_Tenant}, code: codes.Unauthenticated, reason: REASON_TenantMismatch},
+		{name: "wrapped storage error", err: fmt.Errorf(ERROR_CannotDeleteToken, storage.ErrUnavailable), code: codes.Unavailable, reason: REASON_StorageUnavailable},
+		{name: "internal error", err: errors.New("json: unexpected end"), code: codes.Internal, reason: REASON_Internal},
+		{name: "canceled", err: context.Canceled, code: codes.Canceled},
+		{name: "status", err: status.Error(codes.PermissionDenied, "denied"), code: codes.PermissionDenied},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			assertStatus(t, Status(test.err).Err(), test.code, test.reason)
+		})
+	}
+
+	if st := Status(errors.New("dial tcp: connection refused")); st.Message() != ERROR_Internal {
+		t.Errorf("not valid message %q, expected %q", st.Message(), ERROR_Internal)
+	}
+}
+
+// assertStatus checks code of status error and reason of its ErrorInfo.
+// Empty reason expects no details.
+func assertStatus(tb testing.TB, err error, code codes.Code, reason string) {
+	tb.Helper()
+
+	st, ok := status.FromError(err)
+	if !ok || err == nil {
+		tb.Fatalf("not valid error %v, expected status", err)
+	}
+	if st.Code() != code {
+		tb.Errorf("not valid code %v, expected %v: %v", st.Code(), code, st.Message())
+	}
+
+	var info *errdetails.ErrorInfo
+	for _, detail := range st.Details() {
+		if i, ok := detail.(*errdetails.ErrorInfo); ok {
+			info = i
+		}
+	}
+	if reason == "" {
+		if info != nil {
+			tb.Errorf("not valid details %v, expected none", info)
+		}
+		return
+	}
+	if info == nil || info.Reason != reason || info.Domain != ERROR_Domain {
+		tb.Errorf("not valid error info %v, expected reason %q", info, reason)
+	}
+}
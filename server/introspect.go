@@ -0,0 +1,111 @@
+package server
+
+import (
+	"context"
+
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"go.opentelemetry.io/otel"
+)
+
+const (
+	TOKEN_TYPE_Access  = "access_token"
+	TOKEN_TYPE_Refresh = "refresh_token"
+)
+
+// tokenInfo is a parsed token of any type.
+type tokenInfo struct {
+	Type       string
+	UUID       string
+	UserClaims UserClaims
+	jwt.RegisteredClaims
+}
+
+// identifyToken parses access or refresh token. Token of hinted type is
+// parsed first. It returns nil if token is not valid.
+func (s *Server) identifyToken(ctx context.Context, t *tenant, token string, hint string) *tokenInfo {
+	types := []string{TOKEN_TYPE_Access, TOKEN_TYPE_Refresh}
+	if hint == TOKEN_TYPE_Refresh {
+		types = []string{TOKEN_TYPE_Refresh, TOKEN_TYPE_Access}
+	}
+
+	for _, tokenType := range types {
+		switch tokenType {
+		case TOKEN_TYPE_Access:
+			claims, err := s.parseAccessToken(ctx, t, token)
+			if err != nil || claims.UUID == "" {
+				continue
+			}
+			return &tokenInfo{tokenType, claims.UUID, claims.UserClaims, claims.RegisteredClaims}
+		case TOKEN_TYPE_Refresh:
+			claims, err := s.parseRefreshToken(ctx, t, token)
+			if err != nil || claims.RefreshUUID == "" {
+				continue
+			}
+			return &tokenInfo{tokenType, claims.RefreshUUID, claims.UserClaims, claims.RegisteredClaims}
+		}
+	}
+
+	return nil
+}
+
+// tokenKey returns key of token session.
+func (s *Server) tokenKey(t *tenant, info *tokenInfo) string {
+	if info.Type == TOKEN_TYPE_Refresh {
+		return t.schema.Refresh(info.UUID)
+	}
+	return t.schema.Access(info.UUID)
+}
+
+// Introspect returns state of access or refresh token of tenant as described
+// in RFC 7662. Expired, revoked, unknown or not valid token is not active and
+// it is not an error. Token of other tenant is not active.
+func (s *Server) Introspect(ctx context.Context, tenantID string, token string, hint string) (*jwt_gRPC.IntrospectTokenResponse, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "Introspect")
+	defer span.End()
+
+	inactive := &jwt_gRPC.IntrospectTokenResponse{
+		Active: false,
+	}
+
+	t, err := s.tenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	info := s.identifyToken(newCtx, t, token, hint)
+	if info == nil {
+		return inactive, nil
+	}
+
+	session, err := s.getSession(newCtx, s.tokenKey(t, info))
+	if err == storage.ErrNotFound {
+		return inactive, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &jwt_gRPC.IntrospectTokenResponse{
+		Active:     true,
+		TokenType:  info.Type,
+		UserId:     session.UserID,
+		Sub:        info.Subject,
+		Exp:        numericDate(info.ExpiresAt),
+		Iat:        numericDate(info.IssuedAt),
+		Nbf:        numericDate(info.NotBefore),
+		Aud:        info.Audience,
+		Iss:        info.Issuer,
+		Jti:        info.ID,
+		UserClaims: info.UserClaims,
+		TenantId:   t.id,
+	}, nil
+}
+
+func numericDate(date *jwt.NumericDate) int64 {
+	if date == nil {
+		return 0
+	}
+	return date.Unix()
+}
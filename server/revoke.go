@@ -0,0 +1,54 @@
+package server
+
+import (
+	"context"
+
+	"github.com/Moranilt/jwt-http2/storage"
+	"go.opentelemetry.io/otel"
+)
+
+// Revoke revokes access or refresh token of tenant as described in RFC 7009.
+// Paired token and session of the token are revoked too. Not valid, unknown or
+// already revoked token is not an error.
+func (s *Server) Revoke(ctx context.Context, tenantID string, token string, hint string) error {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "Revoke")
+	defer span.End()
+
+	t, err := s.tenant(tenantID)
+	if err != nil {
+		return err
+	}
+
+	info := s.identifyToken(newCtx, t, token, hint)
+	if info == nil {
+		return nil
+	}
+
+	session, err := s.getSession(newCtx, s.tokenKey(t, info))
+	if err == storage.ErrNotFound {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+
+	ops := []storage.Op{storage.DelOp(s.tokenKey(t, info))}
+	if session.AccessUUID != "" {
+		ops = append(ops, storage.DelOp(t.schema.Access(session.AccessUUID)))
+	}
+	if session.RefreshUUID != "" {
+		ops = append(ops, storage.DelOp(t.schema.Refresh(session.RefreshUUID)))
+	}
+
+	if session.FamilyID != "" {
+		family, err := s.getFamily(newCtx, t, session.FamilyID)
+		if err != nil && err != storage.ErrNotFound {
+			return err
+		}
+		if family != nil {
+			ops = append(ops, s.revokeFamilyOps(t, session.FamilyID, family)...)
+		}
+	}
+
+	return s.store.Exec(newCtx, ops...)
+}
@@ -2,16 +2,21 @@ package server
 
 import (
 	"context"
+	"encoding/json"
 	"errors"
 	"fmt"
+	"sort"
+	"sync/atomic"
 	"time"
 
 	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/jwks"
 	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/keyring"
 	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/Moranilt/jwt-http2/storage"
 	"github.com/golang-jwt/jwt/v5"
 	"github.com/google/uuid"
-	"github.com/redis/go-redis/v9"
 	"github.com/sirupsen/logrus"
 	"go.opentelemetry.io/otel"
 )
@@ -19,28 +24,34 @@ import (
 const (
 	TRACE_NAME = "server"
 
-	ERROR_StoreTokenToRedis          = "cannot store token to redis: %v"
-	ERROR_MakeAccessToken            = "make access token: %v"
-	ERROR_MakeRefreshToken           = "make refresh token: %v"
-	ERROR_RefreshTokenNotFound       = "refresh token not found"
-	ERROR_TokenNotFound              = "token not found"
-	ERROR_ProvideAnyField            = "provide any field"
-	ERROR_CannotDeleteTokenFromRedis = "cannot delete token from redis. Error: %v"
+	ERROR_StoreToken           = "cannot store token: %w"
+	ERROR_MakeAccessToken      = "make access token: %w"
+	ERROR_MakeRefreshToken     = "make refresh token: %w"
+	ERROR_RefreshTokenNotFound = "refresh token not found"
+	ERROR_RefreshTokenReused   = "refresh token was already used"
+	ERROR_TokenNotFound        = "token not found"
+	ERROR_ProvideAnyField      = "provide any field"
+	ERROR_ProvideUserId        = "provide user id"
+	ERROR_ProvideToken         = "provide token"
+	ERROR_CannotDeleteToken    = "cannot delete token. Error: %w"
+	ERROR_AlgorithmMismatch    = "token algorithm %q does not match key algorithm %q"
 )
 
 type Server struct {
 	jwt_gRPC.UnimplementedAuthenticationServer
-	log         *logger.Logger
-	config      *config.AppConfig[time.Duration]
-	redis       *redis.Client
-	publicCert  []byte
-	privateCert []byte
+	log *logger.Logger
+	// config is replaced on every update of config provider
+	config atomic.Pointer[config.AppConfig[time.Duration]]
+	store  storage.SessionStore
+	schema storage.KeySchema
+	keys   *keyring.Tenants
 }
 
 type UserClaims = map[string]string
 
 type AccessClaims struct {
 	UUID       string     `json:"session"`
+	Tenant     string     `json:"tenant,omitempty"`
 	UserClaims UserClaims `json:"user_claims"`
 	jwt.RegisteredClaims
 }
@@ -48,6 +59,8 @@ type AccessClaims struct {
 type RefreshClaims struct {
 	AccessUUID  string     `json:"access_uuid"`
 	RefreshUUID string     `json:"refresh_uuid"`
+	FamilyID    string     `json:"family"`
+	Tenant      string     `json:"tenant,omitempty"`
 	UserClaims  UserClaims `json:"user_claims"`
 	jwt.RegisteredClaims
 }
@@ -59,17 +72,27 @@ type AuthTokens struct {
 
 func New(
 	log *logger.Logger,
-	config *config.AppConfig[time.Duration],
-	r *redis.Client,
-	public, private []byte,
-) *Server {
-	return &Server{
-		log:         log,
-		config:      config,
-		redis:       r,
-		publicCert:  public,
-		privateCert: private,
+	provider config.Provider,
+	store storage.SessionStore,
+	schema storage.KeySchema,
+	keys *keyring.Tenants,
+) (*Server, error) {
+	if ring, ok := keys.Keyring(config.DEFAULT_Tenant); !ok || ring.Active() == nil {
+		return nil, errors.New(keyring.ERROR_NoActiveKey)
 	}
+
+	s := &Server{
+		log:    log,
+		store:  store,
+		schema: schema,
+		keys:   keys,
+	}
+	s.config.Store(provider.Current())
+	provider.Subscribe(func(app *config.AppConfig[time.Duration]) {
+		s.config.Store(app)
+	})
+
+	return s, nil
 }
 
 func (s *Server) CreateTokens(ctx context.Context, req *jwt_gRPC.CreateTokensRequest) (*jwt_gRPC.CreateTokensResponse, error) {
@@ -78,10 +101,17 @@ func (s *Server) CreateTokens(ctx context.Context, req *jwt_gRPC.CreateTokensReq
 
 	log := s.log.WithRequestInfo(newCtx)
 	log.WithFields(logrus.Fields{
-		"req": req,
+		"req": s.log.Redact(req),
 	}).Info()
 
-	tokens, err := s.makeNewTokens(newCtx, req.UserId, req.UserClaims)
+	t, err := s.tenant(req.GetTenantId())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	session := newSession(newCtx, req.GetUserId(), req.GetMetadata(), s.config.Load().TrustedProxy)
+	tokens, err := s.makeNewTokens(newCtx, t, session, req.UserClaims, "")
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -99,32 +129,39 @@ func (s *Server) RefreshTokens(ctx context.Context, req *jwt_gRPC.RefreshTokensR
 
 	log := s.log.WithRequestInfo(newCtx)
 	log.WithFields(logrus.Fields{
-		"req": req,
+		"req": s.log.Redact(req),
 	}).Info()
 
-	claims, err := s.parseRefreshToken(newCtx, req.RefreshToken)
+	t, err := s.tenant(req.GetTenantId())
 	if err != nil {
-		log.Error("parse refresh token: ", err)
+		log.Error(err)
 		return nil, err
 	}
 
-	userId, err := s.redis.Get(newCtx, claims.RefreshUUID).Result()
+	claims, err := s.parseRefreshToken(newCtx, t, req.RefreshToken)
 	if err != nil {
-		if err == redis.Nil {
-			log.Error(ERROR_RefreshTokenNotFound)
-			return nil, errors.New(ERROR_RefreshTokenNotFound)
-		}
-		log.Error("redis: ", err)
+		log.Error("parse refresh token: ", err)
 		return nil, err
 	}
 
-	err = s.redis.Del(newCtx, claims.RefreshUUID, claims.AccessUUID).Err()
+	session, err := s.getSession(newCtx, t.schema.Refresh(claims.RefreshUUID))
+	if err == storage.ErrNotFound {
+		return nil, s.refreshTokenNotFound(newCtx, log, t, claims)
+	}
 	if err != nil {
-		log.Error("redis: ", err)
+		log.Error("storage: ", err)
 		return nil, err
 	}
 
-	newTokens, err := s.makeNewTokens(newCtx, userId, claims.UserClaims)
+	session.RefreshedAt = time.Now()
+	newTokens, err := s.makeNewTokens(newCtx, t, session, claims.UserClaims, claims.FamilyID,
+		storage.ConsumeOp(t.schema.Refresh(claims.RefreshUUID)),
+		storage.DelOp(t.schema.Access(claims.AccessUUID)),
+	)
+	// token was rotated by concurrent request
+	if err == storage.ErrNotFound {
+		return nil, s.refreshTokenNotFound(newCtx, log, t, claims)
+	}
 	if err != nil {
 		log.Error(err)
 		return nil, err
@@ -136,60 +173,184 @@ func (s *Server) RefreshTokens(ctx context.Context, req *jwt_gRPC.RefreshTokensR
 	}, nil
 }
 
+// refreshTokenNotFound checks if missing refresh token was reused and
+// returns error for client.
+func (s *Server) refreshTokenNotFound(ctx context.Context, log *logrus.Entry, t *tenant, claims *RefreshClaims) error {
+	reused, err := s.detectReuse(ctx, log, t, claims.FamilyID, claims.RefreshUUID)
+	if err != nil {
+		log.Error("storage: ", err)
+		return err
+	}
+	if reused {
+		return ErrRefreshTokenReused
+	}
+	log.Error(ERROR_RefreshTokenNotFound)
+	return ErrRefreshTokenNotFound
+}
+
 func (s *Server) GetUserId(ctx context.Context, req *jwt_gRPC.GetUserIdRequest) (*jwt_gRPC.GetUserIdResponse, error) {
 	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "GetUserId")
 	defer span.End()
 
 	log := s.log.WithRequestInfo(newCtx)
 	log.WithFields(logrus.Fields{
-		"req": req,
+		"req": s.log.Redact(req),
 	}).Info()
 
-	claims, err := s.parseAccessToken(newCtx, req.AccessToken)
+	t, err := s.tenant(req.GetTenantId())
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
-	userId, err := s.redis.Get(newCtx, claims.UUID).Result()
+	claims, err := s.parseAccessToken(newCtx, t, req.AccessToken)
 	if err != nil {
-		if err == redis.Nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	session, err := s.getSession(newCtx, t.schema.Access(claims.UUID))
+	if err != nil {
+		if err == storage.ErrNotFound {
 			log.Error(ERROR_TokenNotFound)
-			return nil, errors.New(ERROR_TokenNotFound)
+			return nil, ErrTokenNotFound
 		}
-		log.Error("redis: ", err)
+		log.Error("storage: ", err)
 		return nil, err
 	}
 
 	return &jwt_gRPC.GetUserIdResponse{
-		UserId: userId,
+		UserId: session.UserID,
 	}, nil
 }
 
+func (s *Server) IntrospectToken(ctx context.Context, req *jwt_gRPC.IntrospectTokenRequest) (*jwt_gRPC.IntrospectTokenResponse, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "IntrospectToken")
+	defer span.End()
+
+	log := s.log.WithRequestInfo(newCtx)
+	log.WithFields(logrus.Fields{
+		"req": s.log.Redact(req),
+	}).Info()
+
+	if req.GetToken() == "" {
+		log.Error(ERROR_ProvideToken)
+		return nil, ErrProvideToken
+	}
+
+	response, err := s.Introspect(newCtx, req.GetTenantId(), req.GetToken(), req.GetTokenTypeHint())
+	if err != nil {
+		log.Error("storage: ", err)
+		return nil, err
+	}
+
+	return response, nil
+}
+
+func (s *Server) RevokeToken(ctx context.Context, req *jwt_gRPC.RevokeTokenRequest) (*jwt_gRPC.RevokeTokenResponse, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "RevokeToken")
+	defer span.End()
+
+	log := s.log.WithRequestInfo(newCtx)
+	log.WithFields(logrus.Fields{
+		"req": s.log.Redact(req),
+	}).Info()
+
+	if req.GetToken() == "" {
+		log.Error(ERROR_ProvideToken)
+		return nil, ErrProvideToken
+	}
+
+	err := s.Revoke(newCtx, req.GetTenantId(), req.GetToken(), req.GetTokenTypeHint())
+	if err != nil {
+		err = fmt.Errorf(ERROR_CannotDeleteToken, err)
+		log.Error(err)
+		return nil, err
+	}
+
+	return &jwt_gRPC.RevokeTokenResponse{}, nil
+}
+
+func (s *Server) GetSession(ctx context.Context, req *jwt_gRPC.GetSessionRequest) (*jwt_gRPC.Session, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "GetSession")
+	defer span.End()
+
+	log := s.log.WithRequestInfo(newCtx)
+	log.WithFields(logrus.Fields{
+		"req": s.log.Redact(req),
+	}).Info()
+
+	t, err := s.tenant(req.GetTenantId())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	claims, err := s.parseAccessToken(newCtx, t, req.AccessToken)
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	session, err := s.getSession(newCtx, t.schema.Access(claims.UUID))
+	if err != nil {
+		if err == storage.ErrNotFound {
+			log.Error(ERROR_TokenNotFound)
+			return nil, ErrTokenNotFound
+		}
+		log.Error("storage: ", err)
+		return nil, err
+	}
+
+	if session.FamilyID != "" {
+		family, err := s.getFamily(newCtx, t, session.FamilyID)
+		if err == nil {
+			return sessionResponse(session.FamilyID, family), nil
+		}
+		if err != storage.ErrNotFound {
+			log.Error("storage: ", err)
+			return nil, err
+		}
+	}
+
+	// access token is valid but its family was not stored
+	return sessionResponse(session.FamilyID, &Family{
+		Session:         *session,
+		IssuedAt:        claims.IssuedAt.Time,
+		AccessExpiresAt: claims.ExpiresAt.Time,
+	}), nil
+}
+
 func (s *Server) CheckTokenExistence(ctx context.Context, req *jwt_gRPC.CheckTokenExistenceRequest) (*jwt_gRPC.CheckTokenExistenceResponse, error) {
 	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "CheckTokenExistence")
 	defer span.End()
 
 	log := s.log.WithRequestInfo(newCtx)
 	log.WithFields(logrus.Fields{
-		"req": req,
+		"req": s.log.Redact(req),
 	}).Info()
 
 	if req.GetAccessToken() == "" && req.GetRefreshToken() == "" {
 		log.Error(ERROR_ProvideAnyField)
-		return nil, fmt.Errorf(ERROR_ProvideAnyField)
+		return nil, ErrProvideAnyField
+	}
+
+	t, err := s.tenant(req.GetTenantId())
+	if err != nil {
+		log.Error(err)
+		return nil, err
 	}
 
 	response := new(jwt_gRPC.CheckTokenExistenceResponse)
 
 	if req.GetAccessToken() != "" {
-		claims, err := s.parseAccessToken(newCtx, req.GetAccessToken())
+		claims, err := s.parseAccessToken(newCtx, t, req.GetAccessToken())
 		if err != nil {
 			log.Error(err)
 			return nil, err
 		}
 
-		result, err := s.redis.Exists(newCtx, claims.UUID).Result()
+		result, err := s.store.Exists(newCtx, t.schema.Access(claims.UUID))
 		if err != nil {
 			log.Error(err)
 			return nil, err
@@ -200,13 +361,13 @@ func (s *Server) CheckTokenExistence(ctx context.Context, req *jwt_gRPC.CheckTok
 	}
 
 	if req.GetRefreshToken() != "" {
-		claims, err := s.parseRefreshToken(newCtx, req.GetRefreshToken())
+		claims, err := s.parseRefreshToken(newCtx, t, req.GetRefreshToken())
 		if err != nil {
 			log.Error(err)
 			return nil, err
 		}
 
-		result, err := s.redis.Exists(newCtx, claims.RefreshUUID).Result()
+		result, err := s.store.Exists(newCtx, t.schema.Refresh(claims.RefreshUUID))
 		if err != nil {
 			log.Error(err)
 			return nil, err
@@ -225,25 +386,52 @@ func (s *Server) RevokeTokens(ctx context.Context, req *jwt_gRPC.RevokeTokensReq
 
 	log := s.log.WithRequestInfo(newCtx)
 	log.WithFields(logrus.Fields{
-		"req": req,
+		"req": s.log.Redact(req),
 	}).Info()
 
-	claims, err := s.parseRefreshToken(newCtx, req.RefreshToken)
+	t, err := s.tenant(req.GetTenantId())
 	if err != nil {
 		log.Error(err)
 		return nil, err
 	}
 
-	err = s.redis.Del(newCtx, claims.RefreshUUID).Err()
+	claims, err := s.parseRefreshToken(newCtx, t, req.RefreshToken)
 	if err != nil {
-		log.Errorf(ERROR_CannotDeleteTokenFromRedis, err)
-		return nil, fmt.Errorf(ERROR_CannotDeleteTokenFromRedis, err)
+		log.Error(err)
+		return nil, err
+	}
+
+	ops := []storage.Op{
+		storage.DelOp(t.schema.Refresh(claims.RefreshUUID)),
+		storage.DelOp(t.schema.Access(claims.AccessUUID)),
+	}
+	// current pair of family is revoked too, so rotated refresh token can't
+	// leave live session hidden from user sessions
+	if claims.FamilyID != "" {
+		family, err := s.getFamily(newCtx, t, claims.FamilyID)
+		if err != nil && err != storage.ErrNotFound {
+			log.Error("storage: ", err)
+			return nil, err
+		}
+		if family != nil {
+			if family.RefreshUUID != claims.RefreshUUID {
+				log.WithFields(logrus.Fields{
+					"event":        EVENT_RefreshTokenReuse,
+					"tenant":       t.id,
+					"family":       claims.FamilyID,
+					"user_id":      family.UserID,
+					"refresh_uuid": claims.RefreshUUID,
+				}).Warn("rotated refresh token is revoked, token family revoked")
+			}
+			ops = append(ops, s.revokeFamilyOps(t, claims.FamilyID, family)...)
+		}
 	}
 
-	err = s.redis.Del(newCtx, claims.AccessUUID).Err()
+	err = s.store.Exec(newCtx, ops...)
 	if err != nil {
-		log.Errorf(ERROR_CannotDeleteTokenFromRedis, err)
-		return nil, fmt.Errorf(ERROR_CannotDeleteTokenFromRedis, err)
+		err = fmt.Errorf(ERROR_CannotDeleteToken, err)
+		log.Error(err)
+		return nil, err
 	}
 
 	return &jwt_gRPC.RevokeTokensResponse{
@@ -251,30 +439,165 @@ func (s *Server) RevokeTokens(ctx context.Context, req *jwt_gRPC.RevokeTokensReq
 	}, nil
 }
 
-func (s *Server) makeAccessToken(ctx context.Context, uuid string, uc UserClaims, exp time.Time) (string, error) {
+func (s *Server) ListUserSessions(ctx context.Context, req *jwt_gRPC.ListUserSessionsRequest) (*jwt_gRPC.ListUserSessionsResponse, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "ListUserSessions")
+	defer span.End()
+
+	log := s.log.WithRequestInfo(newCtx)
+	log.WithFields(logrus.Fields{
+		"req": s.log.Redact(req),
+	}).Info()
+
+	if req.GetUserId() == "" {
+		log.Error(ERROR_ProvideUserId)
+		return nil, ErrProvideUserId
+	}
+
+	t, err := s.tenant(req.GetTenantId())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	families, err := s.userFamilies(newCtx, t, req.GetUserId())
+	if err != nil {
+		log.Error("storage: ", err)
+		return nil, err
+	}
+
+	response := &jwt_gRPC.ListUserSessionsResponse{
+		Sessions: make([]*jwt_gRPC.Session, 0, len(families)),
+	}
+	for id, family := range families {
+		response.Sessions = append(response.Sessions, sessionResponse(id, family))
+	}
+	sort.Slice(response.Sessions, func(i, j int) bool {
+		return response.Sessions[i].IssuedAt > response.Sessions[j].IssuedAt
+	})
+
+	return response, nil
+}
+
+func (s *Server) RevokeAllUserSessions(ctx context.Context, req *jwt_gRPC.RevokeAllUserSessionsRequest) (*jwt_gRPC.RevokeAllUserSessionsResponse, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "RevokeAllUserSessions")
+	defer span.End()
+
+	log := s.log.WithRequestInfo(newCtx)
+	log.WithFields(logrus.Fields{
+		"req": s.log.Redact(req),
+	}).Info()
+
+	if req.GetUserId() == "" {
+		log.Error(ERROR_ProvideUserId)
+		return nil, ErrProvideUserId
+	}
+
+	t, err := s.tenant(req.GetTenantId())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	families, err := s.userFamilies(newCtx, t, req.GetUserId())
+	if err != nil {
+		log.Error("storage: ", err)
+		return nil, err
+	}
+
+	for id, family := range families {
+		err := s.revokeFamily(newCtx, t, id, family)
+		if err != nil {
+			err = fmt.Errorf(ERROR_CannotDeleteToken, err)
+			log.Error(err)
+			return nil, err
+		}
+	}
+
+	return &jwt_gRPC.RevokeAllUserSessionsResponse{
+		Revoked: int64(len(families)),
+	}, nil
+}
+
+func (s *Server) GetPublicKeys(ctx context.Context, req *jwt_gRPC.GetPublicKeysRequest) (*jwt_gRPC.GetPublicKeysResponse, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "GetPublicKeys")
+	defer span.End()
+
+	log := s.log.WithRequestInfo(newCtx)
+
+	set, err := s.PublicKeys(newCtx, req.GetTenantId())
+	if err != nil {
+		log.Error(err)
+		return nil, err
+	}
+
+	response := &jwt_gRPC.GetPublicKeysResponse{
+		Keys: make([]*jwt_gRPC.JSONWebKey, 0, len(set.Keys)),
+	}
+	for _, key := range set.Keys {
+		response.Keys = append(response.Keys, &jwt_gRPC.JSONWebKey{
+			Kty: key.Kty,
+			Kid: key.Kid,
+			Alg: key.Alg,
+			Use: key.Use,
+			N:   key.N,
+			E:   key.E,
+			Crv: key.Crv,
+			X:   key.X,
+			Y:   key.Y,
+		})
+	}
+
+	return response, nil
+}
+
+// PublicKeys returns keys of tenant to verify signature of issued tokens.
+func (s *Server) PublicKeys(ctx context.Context, tenantID string) (*jwks.Set, error) {
+	_, span := otel.Tracer(TRACE_NAME).Start(ctx, "PublicKeys")
+	defer span.End()
+
+	t, err := s.tenant(tenantID)
+	if err != nil {
+		return nil, err
+	}
+
+	set := &jwks.Set{
+		Keys: make([]jwks.Key, 0),
+	}
+	for _, k := range t.keys.Keys() {
+		key, err := jwks.FromPublicKey(k.ID, k.Algorithm, k.Public)
+		if err != nil {
+			return nil, err
+		}
+		set.Keys = append(set.Keys, *key)
+	}
+
+	return set, nil
+}
+
+func (s *Server) makeAccessToken(ctx context.Context, t *tenant, uuid string, uc UserClaims, exp time.Time) (string, error) {
 	_, span := otel.Tracer(TRACE_NAME).Start(ctx, "makeAccessToken")
 	defer span.End()
 
+	cfg := t.config
 	claims := AccessClaims{
 		UUID:       uuid,
+		Tenant:     t.id,
 		UserClaims: uc,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(exp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    s.config.Issuer,
-			Subject:   s.config.Subject,
-			Audience:  s.config.Audience,
+			Issuer:    cfg.Issuer,
+			Subject:   cfg.Subject,
+			Audience:  cfg.Audience,
 			ID:        uuid,
 		},
 	}
 
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(s.privateCert)
-	if err != nil {
-		return "", err
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	access_token, err := token.SignedString(key)
+	active := t.keys.Active()
+	token := jwt.NewWithClaims(active.Method, claims)
+	token.Header["kid"] = active.ID
+	access_token, err := token.SignedString(active.Signer)
 	if err != nil {
 		return "", errors.New("cannot create new token. Error: " + err.Error())
 	}
@@ -282,30 +605,31 @@ func (s *Server) makeAccessToken(ctx context.Context, uuid string, uc UserClaims
 	return access_token, nil
 }
 
-func (s *Server) makeRefreshToken(ctx context.Context, accessUUID string, refreshUUID string, uc UserClaims, refreshExp time.Time) (string, error) {
+func (s *Server) makeRefreshToken(ctx context.Context, t *tenant, accessUUID string, refreshUUID string, familyID string, uc UserClaims, refreshExp time.Time) (string, error) {
 	_, span := otel.Tracer(TRACE_NAME).Start(ctx, "makeRefreshToken")
 	defer span.End()
 
+	cfg := t.config
 	claims := RefreshClaims{
 		AccessUUID:  accessUUID,
 		RefreshUUID: refreshUUID,
+		FamilyID:    familyID,
+		Tenant:      t.id,
 		UserClaims:  uc,
 		RegisteredClaims: jwt.RegisteredClaims{
 			ExpiresAt: jwt.NewNumericDate(refreshExp),
 			IssuedAt:  jwt.NewNumericDate(time.Now()),
 			NotBefore: jwt.NewNumericDate(time.Now()),
-			Issuer:    s.config.Issuer,
-			Subject:   s.config.Subject,
-			Audience:  s.config.Audience,
+			Issuer:    cfg.Issuer,
+			Subject:   cfg.Subject,
+			Audience:  cfg.Audience,
 			ID:        refreshUUID,
 		},
 	}
-	key, err := jwt.ParseRSAPrivateKeyFromPEM(s.privateCert)
-	if err != nil {
-		return "", err
-	}
-	token := jwt.NewWithClaims(jwt.SigningMethodRS256, claims)
-	refresh_token, err := token.SignedString(key)
+	active := t.keys.Active()
+	token := jwt.NewWithClaims(active.Method, claims)
+	token.Header["kid"] = active.ID
+	refresh_token, err := token.SignedString(active.Signer)
 	if err != nil {
 		return "", errors.New("cannot create new token. Error: " + err.Error())
 	}
@@ -313,91 +637,129 @@ func (s *Server) makeRefreshToken(ctx context.Context, accessUUID string, refres
 	return refresh_token, nil
 }
 
-func (s *Server) makeJwtOptions(options ...jwt.ParserOption) []jwt.ParserOption {
+// verificationKey finds public key of tenant by kid from token header. Tokens
+// issued before kid was added are verified by active key. Token is rejected if
+// its alg doesn't match algorithm of the key.
+func (t *tenant) verificationKey(token *jwt.Token) (any, error) {
+	key := t.keys.Active()
+	if kid, ok := token.Header["kid"].(string); ok {
+		key, ok = t.keys.Get(kid)
+		if !ok {
+			return nil, fmt.Errorf("%w %q", ErrUnknownKey, kid)
+		}
+	}
+
+	if token.Method.Alg() != key.Algorithm {
+		return nil, fmt.Errorf("%w: "+ERROR_AlgorithmMismatch, jwt.ErrTokenSignatureInvalid, token.Method.Alg(), key.Algorithm)
+	}
+
+	return key.Public, nil
+}
+
+func (t *tenant) makeJwtOptions(options ...jwt.ParserOption) []jwt.ParserOption {
+	cfg := t.config
 	var o []jwt.ParserOption
 	o = append(o, options...)
-	o = append(o, jwt.WithSubject(s.config.Subject), jwt.WithIssuer(s.config.Issuer))
-	for _, aud := range s.config.Audience {
+	o = append(o, jwt.WithSubject(cfg.Subject), jwt.WithIssuer(cfg.Issuer))
+	for _, aud := range cfg.Audience {
 		o = append(o, jwt.WithAudience(aud))
 	}
 	return o
 }
 
-func (s *Server) parseRefreshToken(ctx context.Context, refreshToken string) (*RefreshClaims, error) {
+func (s *Server) parseRefreshToken(ctx context.Context, t *tenant, refreshToken string) (*RefreshClaims, error) {
 	_, span := otel.Tracer(TRACE_NAME).Start(ctx, "parseRefreshToken")
 	defer span.End()
 
-	token, err := jwt.ParseWithClaims(refreshToken, &RefreshClaims{}, func(t *jwt.Token) (any, error) {
-		key, err := jwt.ParseRSAPublicKeyFromPEM(s.publicCert)
-		if err != nil {
-			return nil, err
-		}
-		return key, nil
-	}, s.makeJwtOptions()...)
+	token, err := jwt.ParseWithClaims(refreshToken, &RefreshClaims{}, t.verificationKey, t.makeJwtOptions()...)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*RefreshClaims); ok && token.Valid {
-		return claims, nil
+		return claims, t.checkTenant(claims.Tenant)
 	} else {
-		return nil, errors.New("not valid token claims")
+		return nil, ErrInvalidClaims
 	}
 }
 
-func (s *Server) parseAccessToken(ctx context.Context, refreshToken string) (*AccessClaims, error) {
+func (s *Server) parseAccessToken(ctx context.Context, t *tenant, refreshToken string) (*AccessClaims, error) {
 	_, span := otel.Tracer(TRACE_NAME).Start(ctx, "parseAccessToken")
 	defer span.End()
 
-	token, err := jwt.ParseWithClaims(refreshToken, &AccessClaims{}, func(t *jwt.Token) (any, error) {
-		key, err := jwt.ParseRSAPublicKeyFromPEM(s.publicCert)
-		if err != nil {
-			return nil, err
-		}
-		return key, nil
-	}, s.makeJwtOptions()...)
+	token, err := jwt.ParseWithClaims(refreshToken, &AccessClaims{}, t.verificationKey, t.makeJwtOptions()...)
 
 	if err != nil {
 		return nil, err
 	}
 
 	if claims, ok := token.Claims.(*AccessClaims); ok && token.Valid {
-		return claims, nil
+		return claims, t.checkTenant(claims.Tenant)
 	} else {
-		return nil, errors.New("not valid token claims")
+		return nil, ErrInvalidClaims
 	}
 }
 
-func (s *Server) makeNewTokens(ctx context.Context, userId string, userClaims UserClaims) (*AuthTokens, error) {
+// makeNewTokens issues new pair of tokens for session. Empty familyID starts new family.
+// New pair is stored atomically with ops, so ConsumeOp of previous refresh
+// token makes rotation happen only once.
+func (s *Server) makeNewTokens(ctx context.Context, t *tenant, session *Session, userClaims UserClaims, familyID string, ops ...storage.Op) (*AuthTokens, error) {
 	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "makeNewTokens")
 	defer span.End()
 
+	if familyID == "" {
+		familyID = uuid.NewString()
+	}
+	session.FamilyID = familyID
+
+	ttl := t.config.TTL
 	now := time.Now()
 	accessUUID := uuid.NewString()
-	accessExp := now.Add(s.config.TTL.Access)
+	accessExp := now.Add(ttl.Access)
 
 	refreshUUID := uuid.NewString()
-	refreshExp := now.Add(s.config.TTL.Refresh)
+	refreshExp := now.Add(ttl.Refresh)
 
-	access_token, err := s.makeAccessToken(newCtx, accessUUID, userClaims, accessExp)
+	access_token, err := s.makeAccessToken(newCtx, t, accessUUID, userClaims, accessExp)
 	if err != nil {
 		return nil, fmt.Errorf(ERROR_MakeAccessToken, err)
 	}
 
-	refresh_token, err := s.makeRefreshToken(newCtx, accessUUID, refreshUUID, userClaims, refreshExp)
+	refresh_token, err := s.makeRefreshToken(newCtx, t, accessUUID, refreshUUID, familyID, userClaims, refreshExp)
 	if err != nil {
 		return nil, fmt.Errorf(ERROR_MakeRefreshToken, err)
 	}
 
-	err = s.redis.Set(newCtx, accessUUID, userId, time.Until(accessExp)).Err()
+	session.AccessUUID = accessUUID
+	session.RefreshUUID = refreshUUID
+	value, err := json.Marshal(session)
 	if err != nil {
-		return nil, fmt.Errorf(ERROR_StoreTokenToRedis, err)
+		return nil, fmt.Errorf(ERROR_StoreToken, err)
 	}
 
-	err = s.redis.Set(newCtx, refreshUUID, userId, time.Until(refreshExp)).Err()
+	family, err := s.familyOps(t, familyID, &Family{
+		Session:          *session,
+		IssuedAt:         now,
+		AccessExpiresAt:  accessExp,
+		RefreshExpiresAt: refreshExp,
+	})
+	if err != nil {
+		return nil, fmt.Errorf(ERROR_StoreToken, err)
+	}
+
+	ops = append(ops,
+		storage.SetOp(t.schema.Access(accessUUID), string(value), time.Until(accessExp)),
+		storage.SetOp(t.schema.Refresh(refreshUUID), string(value), time.Until(refreshExp)),
+	)
+	ops = append(ops, family...)
+
+	err = s.store.Exec(newCtx, ops...)
+	if err == storage.ErrNotFound {
+		return nil, err
+	}
 	if err != nil {
-		return nil, fmt.Errorf(ERROR_StoreTokenToRedis, err)
+		return nil, fmt.Errorf(ERROR_StoreToken, err)
 	}
 
 	return &AuthTokens{
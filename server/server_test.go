@@ -0,0 +1,1008 @@
+package server
+
+import (
+	"context"
+	"crypto/x509"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/keyring"
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/golang-jwt/jwt/v5"
+	"github.com/sirupsen/logrus/hooks/test"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/proto"
+)
+
+const (
+	testConfigKey = "auth/v1.0.0/config.yaml"
+	testConfig    = `
+issuer: authentication
+subject: user
+audience:
+  - http://localhost:8080
+algorithm: RS256
+ttl:
+  access: 15m
+  refresh: 7d
+`
+)
+
+func newTestServer(tb testing.TB) *Server {
+	tb.Helper()
+	return newTestServerWithConfig(tb, newTestConfig(tb))
+}
+
+// newTestConfig makes config updated by Consul watch.
+func newTestConfig(tb testing.TB) *config.Config {
+	tb.Helper()
+
+	log := logger.New()
+	log.Out = io.Discard
+
+	cfg := config.New(log)
+	watchTestConfig(tb, cfg, testConfig)
+	return cfg
+}
+
+func watchTestConfig(tb testing.TB, cfg *config.Config, yaml string) {
+	tb.Helper()
+
+	err := cfg.WatchConsul(context.Background(), testConfigKey, []config.WatchConsulBody{
+		{Key: testConfigKey, Value: base64.StdEncoding.EncodeToString([]byte(yaml))},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+func newTestServerWithConfig(tb testing.TB, cfg config.Provider) *Server {
+	tb.Helper()
+
+	log := logger.New()
+	log.Out = io.Discard
+	return newTestServerWithLogger(tb, cfg, log)
+}
+
+func newTestServerWithLogger(tb testing.TB, cfg config.Provider, log *logger.Logger) *Server {
+	tb.Helper()
+
+	keys := keyring.NewTenants(log, cfg, func(string) keyring.Store {
+		return keyring.NewMemoryStore()
+	})
+	err := keys.Init(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	s, err := New(log, cfg, storage.NewMemory(), storage.NewKeySchema(storage.DEFAULT_KeyPrefix), keys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return s
+}
+
+func (s *Server) mustTenant(tb testing.TB, id string) *tenant {
+	tb.Helper()
+
+	t, err := s.tenant(id)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return t
+}
+
+func (s *Server) mustCreateTokens(tb testing.TB) *jwt_gRPC.CreateTokensResponse {
+	tb.Helper()
+
+	tokens, err := s.CreateTokens(context.Background(), &jwt_gRPC.CreateTokensRequest{UserId: "1"})
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return tokens
+}
+
+func TestNewWithoutActiveKey(t *testing.T) {
+	_, err := New(logger.New(), nil, nil, storage.KeySchema{}, keyring.NewTenants(logger.New(), nil, nil))
+	if err == nil || err.Error() != keyring.ERROR_NoActiveKey {
+		t.Errorf("not valid error %v, expected %q", err, keyring.ERROR_NoActiveKey)
+	}
+}
+
+func TestCreateTokens(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{
+		UserId:     "1",
+		UserClaims: map[string]string{"role": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	access, err := s.parseAccessToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.UserClaims["role"] != "admin" {
+		t.Errorf("not valid user claims %v", access.UserClaims)
+	}
+
+	refresh, err := s.parseRefreshToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if refresh.AccessUUID != access.UUID || refresh.FamilyID == "" {
+		t.Errorf("not valid refresh claims %v", refresh)
+	}
+
+	token, _, err := jwt.NewParser().ParseUnverified(tokens.AccessToken, &AccessClaims{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if kid := token.Header["kid"]; kid != s.mustTenant(t, config.DEFAULT_Tenant).keys.Active().ID {
+		t.Errorf("not valid kid %v, expected %q", kid, s.mustTenant(t, config.DEFAULT_Tenant).keys.Active().ID)
+	}
+
+	count, err := s.store.Exists(ctx, s.schema.Access(access.UUID), s.schema.Refresh(refresh.RefreshUUID), s.schema.Family(refresh.FamilyID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 3 {
+		t.Errorf("not valid count of stored keys %d, expected 3", count)
+	}
+}
+
+func TestRefreshTokens(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{
+		UserId:     "1",
+		UserClaims: map[string]string{"role": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		{name: "access token", token: tokens.AccessToken},
+		{name: "not valid token", token: "not.valid.token"},
+		{name: "token of other key", token: newTestServer(t).mustCreateTokens(t).RefreshToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: test.token})
+			if err == nil {
+				t.Error("expected error")
+			}
+		})
+	}
+
+	rotated, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken})
+	if err == nil || err.Error() != ERROR_TokenNotFound {
+		t.Errorf("previous access token: not valid error %v, expected %q", err, ERROR_TokenNotFound)
+	}
+
+	access, err := s.parseAccessToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), rotated.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if access.UserClaims["role"] != "admin" {
+		t.Errorf("user claims were not kept: %v", access.UserClaims)
+	}
+
+	previous, err := s.parseRefreshToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), tokens.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	current, err := s.parseRefreshToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), rotated.RefreshToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if current.FamilyID != previous.FamilyID {
+		t.Errorf("not valid family %q, expected %q", current.FamilyID, previous.FamilyID)
+	}
+}
+
+func TestRefreshTokensConcurrently(t *testing.T) {
+	const workers = 50
+
+	s := newTestServer(t)
+	ctx := context.Background()
+	tokens := s.mustCreateTokens(t)
+
+	var (
+		mu      sync.Mutex
+		rotated []*jwt_gRPC.RefreshTokenResponse
+		wg      sync.WaitGroup
+		start   = make(chan struct{})
+	)
+	for i := 0; i < workers; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			<-start
+
+			pair, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+			if err != nil {
+				if err.Error() != ERROR_RefreshTokenReused && err.Error() != ERROR_RefreshTokenNotFound {
+					t.Errorf("not valid error %v", err)
+				}
+				return
+			}
+
+			mu.Lock()
+			rotated = append(rotated, pair)
+			mu.Unlock()
+		}()
+	}
+	close(start)
+	wg.Wait()
+
+	if len(rotated) != 1 {
+		t.Fatalf("refresh token was redeemed %d times, expected once", len(rotated))
+	}
+
+	// other attempts are reuse of rotated token, so family of the only new
+	// pair is revoked
+	_, err := s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: rotated[0].AccessToken})
+	if err == nil || err.Error() != ERROR_TokenNotFound {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_TokenNotFound)
+	}
+
+	list, err := s.ListUserSessions(ctx, &jwt_gRPC.ListUserSessionsRequest{UserId: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sessions) != 0 {
+		t.Errorf("not valid sessions count %d, expected 0", len(list.Sessions))
+	}
+}
+
+func TestGetUserId(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens := s.mustCreateTokens(t)
+	revoked := s.mustCreateTokens(t)
+	_, err := s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: revoked.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		token  string
+		userId string
+		err    string
+	}{
+		{name: "access token", token: tokens.AccessToken, userId: "1"},
+		{name: "revoked token", token: revoked.AccessToken, err: ERROR_TokenNotFound},
+		{name: "refresh token", token: tokens.RefreshToken, err: ERROR_TokenNotFound},
+		{name: "not valid token", token: "not.valid.token"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: test.token})
+			if test.userId == "" {
+				if err == nil {
+					t.Fatal("expected error")
+				}
+				if test.err != "" && err.Error() != test.err {
+					t.Errorf("not valid error %v, expected %q", err, test.err)
+				}
+				return
+			}
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.UserId != test.userId {
+				t.Errorf("not valid user id %q, expected %q", result.UserId, test.userId)
+			}
+		})
+	}
+}
+
+func TestCheckTokenExistence(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	_, err := s.CheckTokenExistence(ctx, &jwt_gRPC.CheckTokenExistenceRequest{})
+	if err == nil || err.Error() != ERROR_ProvideAnyField {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_ProvideAnyField)
+	}
+
+	tokens := s.mustCreateTokens(t)
+	req := &jwt_gRPC.CheckTokenExistenceRequest{
+		AccessToken:  &tokens.AccessToken,
+		RefreshToken: &tokens.RefreshToken,
+	}
+
+	result, err := s.CheckTokenExistence(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.GetAccessToken() || !result.GetRefreshToken() {
+		t.Errorf("issued tokens do not exist: %v", result)
+	}
+
+	_, err = s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	result, err = s.CheckTokenExistence(ctx, req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.AccessToken == nil || result.GetAccessToken() || result.RefreshToken == nil || result.GetRefreshToken() {
+		t.Errorf("revoked tokens exist: %v", result)
+	}
+}
+
+func TestRevokeTokens(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens := s.mustCreateTokens(t)
+
+	_, err := s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: "not.valid.token"})
+	if err == nil {
+		t.Error("expected error for not valid token")
+	}
+
+	result, err := s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Revoked {
+		t.Error("tokens were not revoked")
+	}
+
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken})
+	if err == nil || err.Error() != ERROR_TokenNotFound {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_TokenNotFound)
+	}
+
+	_, err = s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err == nil || err.Error() != ERROR_RefreshTokenNotFound {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_RefreshTokenNotFound)
+	}
+}
+
+func TestRevokeTokensRotated(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens := s.mustCreateTokens(t)
+	rotated, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// already rotated refresh token revokes current pair of its family
+	result, err := s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !result.Revoked {
+		t.Error("tokens were not revoked")
+	}
+
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: rotated.AccessToken})
+	if err == nil || err.Error() != ERROR_TokenNotFound {
+		t.Errorf("current access token: not valid error %v, expected %q", err, ERROR_TokenNotFound)
+	}
+	_, err = s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: rotated.RefreshToken})
+	if err == nil || err.Error() != ERROR_RefreshTokenNotFound {
+		t.Errorf("current refresh token: not valid error %v, expected %q", err, ERROR_RefreshTokenNotFound)
+	}
+
+	// family is removed from user sessions
+	count, err := s.store.Exists(ctx, s.schema.User("1"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 0 {
+		t.Errorf("not valid count of user sessions keys %d, expected 0", count)
+	}
+}
+
+func TestGetPublicKeys(t *testing.T) {
+	s := newTestServer(t)
+
+	result, err := s.GetPublicKeys(context.Background(), &jwt_gRPC.GetPublicKeysRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(result.Keys) != 1 {
+		t.Fatalf("not valid keys count %d, expected 1", len(result.Keys))
+	}
+
+	key := result.Keys[0]
+	active := s.mustTenant(t, config.DEFAULT_Tenant).keys.Active()
+	if key.Kid != active.ID || key.Alg != active.Algorithm || key.Kty != "RSA" || key.N == "" || key.E == "" {
+		t.Errorf("not valid key %v", key)
+	}
+}
+
+func TestConfigReload(t *testing.T) {
+	cfg := newTestConfig(t)
+	s := newTestServerWithConfig(t, cfg)
+	ctx := context.Background()
+
+	expiresIn := func() time.Duration {
+		tokens := s.mustCreateTokens(t)
+		claims, err := s.parseAccessToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), tokens.AccessToken)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return time.Until(claims.ExpiresAt.Time).Round(time.Minute)
+	}
+
+	if ttl := expiresIn(); ttl != 15*time.Minute {
+		t.Fatalf("not valid ttl %s, expected %s", ttl, 15*time.Minute)
+	}
+
+	watchTestConfig(t, cfg, strings.Replace(testConfig, "access: 15m", "access: 5m", 1))
+
+	if ttl := expiresIn(); ttl != 5*time.Minute {
+		t.Errorf("not valid ttl %s after reload, expected %s", ttl, 5*time.Minute)
+	}
+}
+
+func TestRefreshTokensReuse(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{UserId: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err == nil || err.Error() != ERROR_RefreshTokenReused {
+		t.Fatalf("not valid error %v, expected %q", err, ERROR_RefreshTokenReused)
+	}
+
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: rotated.AccessToken})
+	if err == nil || err.Error() != ERROR_TokenNotFound {
+		t.Errorf("access token of revoked family: not valid error %v, expected %q", err, ERROR_TokenNotFound)
+	}
+
+	_, err = s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: rotated.RefreshToken})
+	if err == nil || err.Error() != ERROR_RefreshTokenNotFound {
+		t.Errorf("refresh token of revoked family: not valid error %v, expected %q", err, ERROR_RefreshTokenNotFound)
+	}
+}
+
+func TestUserSessions(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	var tokens []*jwt_gRPC.CreateTokensResponse
+	for i := 0; i < 2; i++ {
+		pair, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{UserId: "1"})
+		if err != nil {
+			t.Fatal(err)
+		}
+		tokens = append(tokens, pair)
+	}
+	_, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{UserId: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: tokens[1].RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list, err := s.ListUserSessions(ctx, &jwt_gRPC.ListUserSessionsRequest{UserId: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sessions) != 1 {
+		t.Fatalf("not valid sessions count %d, expected 1", len(list.Sessions))
+	}
+	session := list.Sessions[0]
+	if session.IssuedAt == 0 || session.AccessExpiresAt <= session.IssuedAt || session.RefreshExpiresAt <= session.AccessExpiresAt {
+		t.Errorf("not valid session times %v", session)
+	}
+
+	revoked, err := s.RevokeAllUserSessions(ctx, &jwt_gRPC.RevokeAllUserSessionsRequest{UserId: "1"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if revoked.Revoked != 1 {
+		t.Errorf("not valid revoked count %d, expected 1", revoked.Revoked)
+	}
+
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens[0].AccessToken})
+	if err == nil || err.Error() != ERROR_TokenNotFound {
+		t.Errorf("not valid error %v, expected %q", err, ERROR_TokenNotFound)
+	}
+
+	list, err = s.ListUserSessions(ctx, &jwt_gRPC.ListUserSessionsRequest{UserId: "2"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list.Sessions) != 1 {
+		t.Errorf("sessions of other user were revoked")
+	}
+}
+
+func TestGetSession(t *testing.T) {
+	cfg := newTestConfig(t)
+	watchTestConfig(t, cfg, testConfig+"trusted_proxies: [10.0.0.0/8]\n")
+	s := newTestServerWithConfig(t, cfg)
+	ctx := metadata.NewIncomingContext(context.Background(), metadata.Pairs(
+		MD_ForwardedFor, "10.0.0.1, 203.0.113.7, 10.0.0.2",
+		MD_UserAgent, "Mozilla/5.0",
+	))
+	ctx = peer.NewContext(ctx, &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP("10.0.0.3"), Port: 51234}})
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{
+		UserId: "1",
+		Metadata: &jwt_gRPC.SessionMetadata{
+			DeviceName: "iPhone",
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rotated, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	session, err := s.GetSession(ctx, &jwt_gRPC.GetSessionRequest{AccessToken: rotated.AccessToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if session.UserId != "1" {
+		t.Errorf("not valid user id %q, expected %q", session.UserId, "1")
+	}
+	expected := &jwt_gRPC.SessionMetadata{Ip: "203.0.113.7", UserAgent: "Mozilla/5.0", DeviceName: "iPhone"}
+	if !proto.Equal(session.Metadata, expected) {
+		t.Errorf("not valid metadata %v, expected %v", session.Metadata, expected)
+	}
+	if session.CreatedAt == 0 || session.RefreshedAt < session.CreatedAt {
+		t.Errorf("not valid created %d and refreshed %d times", session.CreatedAt, session.RefreshedAt)
+	}
+}
+
+func TestClientIP(t *testing.T) {
+	app := &config.AppConfig[time.Duration]{TrustedProxies: []string{"10.0.0.0/8", "192.0.2.1"}}
+
+	tests := []struct {
+		name      string
+		peer      string
+		forwarded []string
+		ip        string
+	}{
+		{name: "without forwarded", peer: "203.0.113.7", ip: "203.0.113.7"},
+		{name: "spoofed forwarded", peer: "203.0.113.7", forwarded: []string{"198.51.100.1"}, ip: "203.0.113.7"},
+		{name: "trusted proxy", peer: "10.0.0.3", forwarded: []string{"198.51.100.1"}, ip: "198.51.100.1"},
+		{name: "right-most untrusted hop", peer: "10.0.0.3", forwarded: []string{"198.51.100.1, 203.0.113.7, 192.0.2.1"}, ip: "203.0.113.7"},
+		{name: "several headers", peer: "10.0.0.3", forwarded: []string{"198.51.100.1", "203.0.113.7"}, ip: "203.0.113.7"},
+		{name: "only trusted hops", peer: "10.0.0.3", forwarded: []string{"10.0.0.1"}, ip: "10.0.0.1"},
+		{name: "not valid hop", peer: "10.0.0.3", forwarded: []string{"198.51.100.1, unknown"}, ip: "10.0.0.3"},
+		{name: "trusted proxy without forwarded", peer: "10.0.0.3", ip: "10.0.0.3"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			md := metadata.MD{}
+			md.Set(MD_ForwardedFor, test.forwarded...)
+			ctx := peer.NewContext(context.Background(), &peer.Peer{Addr: &net.TCPAddr{IP: net.ParseIP(test.peer), Port: 51234}})
+
+			if ip := clientIP(ctx, md, app.TrustedProxy); ip != test.ip {
+				t.Errorf("not valid ip %q, expected %q", ip, test.ip)
+			}
+		})
+	}
+
+	if ip := clientIP(context.Background(), metadata.Pairs(MD_ForwardedFor, "198.51.100.1"), app.TrustedProxy); ip != "" {
+		t.Errorf("not valid ip without peer %q, expected empty", ip)
+	}
+}
+
+func TestIntrospectToken(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{
+		UserId:     "1",
+		UserClaims: map[string]string{"role": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name      string
+		token     string
+		hint      string
+		tokenType string
+	}{
+		{name: "access token", token: tokens.AccessToken, tokenType: TOKEN_TYPE_Access},
+		{name: "refresh token", token: tokens.RefreshToken, tokenType: TOKEN_TYPE_Refresh},
+		{name: "refresh token with hint", token: tokens.RefreshToken, hint: TOKEN_TYPE_Refresh, tokenType: TOKEN_TYPE_Refresh},
+		{name: "access token with wrong hint", token: tokens.AccessToken, hint: TOKEN_TYPE_Refresh, tokenType: TOKEN_TYPE_Access},
+		{name: "not valid token", token: "not.valid.token"},
+		{name: "token of other key", token: newTestServer(t).mustCreateTokens(t).AccessToken},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			result, err := s.IntrospectToken(ctx, &jwt_gRPC.IntrospectTokenRequest{Token: test.token, TokenTypeHint: &test.hint})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.Active != (test.tokenType != "") {
+				t.Fatalf("not valid active %t", result.Active)
+			}
+			if !result.Active {
+				return
+			}
+			if result.TokenType != test.tokenType {
+				t.Errorf("not valid token type %q, expected %q", result.TokenType, test.tokenType)
+			}
+			if result.UserId != "1" || result.UserClaims["role"] != "admin" || result.Exp <= result.Iat || result.Jti == "" {
+				t.Errorf("not valid introspection %v", result)
+			}
+		})
+	}
+
+	_, err = s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	result, err := s.IntrospectToken(ctx, &jwt_gRPC.IntrospectTokenRequest{Token: tokens.AccessToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if result.Active {
+		t.Errorf("revoked token is active")
+	}
+}
+
+func TestRevokeToken(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+
+	tests := []struct {
+		name  string
+		token func(*jwt_gRPC.CreateTokensResponse) string
+		hint  string
+	}{
+		{name: "access token", token: func(r *jwt_gRPC.CreateTokensResponse) string { return r.AccessToken }},
+		{name: "access token with hint", token: func(r *jwt_gRPC.CreateTokensResponse) string { return r.AccessToken }, hint: TOKEN_TYPE_Access},
+		{name: "refresh token", token: func(r *jwt_gRPC.CreateTokensResponse) string { return r.RefreshToken }},
+		{name: "refresh token with hint", token: func(r *jwt_gRPC.CreateTokensResponse) string { return r.RefreshToken }, hint: TOKEN_TYPE_Refresh},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			tokens := s.mustCreateTokens(t)
+
+			_, err := s.RevokeToken(ctx, &jwt_gRPC.RevokeTokenRequest{Token: test.token(tokens), TokenTypeHint: &test.hint})
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			for tokenType, token := range map[string]string{
+				TOKEN_TYPE_Access:  tokens.AccessToken,
+				TOKEN_TYPE_Refresh: tokens.RefreshToken,
+			} {
+				result, err := s.IntrospectToken(ctx, &jwt_gRPC.IntrospectTokenRequest{Token: token})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if result.Active {
+					t.Errorf("%s is active after revocation", tokenType)
+				}
+			}
+		})
+	}
+
+	_, err := s.RevokeToken(ctx, &jwt_gRPC.RevokeTokenRequest{Token: "not.valid.token"})
+	if err != nil {
+		t.Errorf("not expected error for not valid token: %v", err)
+	}
+}
+
+func BenchmarkCreateTokens(b *testing.B) {
+	s := newTestServer(b)
+	ctx := context.Background()
+	req := &jwt_gRPC.CreateTokensRequest{
+		UserId:     "1",
+		UserClaims: map[string]string{"role": "admin"},
+	}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.CreateTokens(ctx, req)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func BenchmarkGetUserId(b *testing.B) {
+	s := newTestServer(b)
+	ctx := context.Background()
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{UserId: "1"})
+	if err != nil {
+		b.Fatal(err)
+	}
+	req := &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken}
+
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		_, err := s.GetUserId(ctx, req)
+		if err != nil {
+			b.Fatal(err)
+		}
+	}
+}
+
+func TestTenants(t *testing.T) {
+	cfg := newTestConfig(t)
+	watchTestConfig(t, cfg, testConfig+`
+tenants:
+  shop:
+    issuer: shop
+    audience:
+      - http://shop.localhost
+    algorithm: ES256
+    ttl:
+      access: 5m
+`)
+	s := newTestServerWithConfig(t, cfg)
+	ctx := context.Background()
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{UserId: "1", TenantId: "shop"})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	shop := s.mustTenant(t, "shop")
+	claims, err := s.parseAccessToken(ctx, shop, tokens.AccessToken)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if claims.Tenant != "shop" || claims.Issuer != "shop" || claims.Audience[0] != "http://shop.localhost" || claims.Subject != "user" {
+		t.Errorf("not valid claims %+v", claims)
+	}
+	if ttl := time.Until(claims.ExpiresAt.Time).Round(time.Minute); ttl != 5*time.Minute {
+		t.Errorf("not valid ttl %s, expected %s", ttl, 5*time.Minute)
+	}
+	if active := shop.keys.Active(); active.Algorithm != config.ALGORITHM_ES256 || active.ID == s.mustTenant(t, config.DEFAULT_Tenant).keys.Active().ID {
+		t.Errorf("not valid key of tenant %s %s", active.ID, active.Algorithm)
+	}
+
+	// keys of tenant are stored with tenant prefix
+	count, err := s.store.Exists(ctx, shop.schema.Access(claims.UUID), s.schema.Access(claims.UUID))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if count != 1 || shop.schema.Access(claims.UUID) != "auth:tenant:shop:access:"+claims.UUID {
+		t.Errorf("not valid keys %q count %d, expected 1", shop.schema.Access(claims.UUID), count)
+	}
+
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken, TenantId: "shop"})
+	if err != nil {
+		t.Errorf("not expected error %v", err)
+	}
+
+	// token of tenant is not valid for other tenant
+	_, err = s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken})
+	if err == nil {
+		t.Error("expected error for token of other tenant")
+	}
+	_, err = s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err == nil {
+		t.Error("expected error for refresh token of other tenant")
+	}
+	introspection, err := s.IntrospectToken(ctx, &jwt_gRPC.IntrospectTokenRequest{Token: tokens.AccessToken})
+	if err != nil || introspection.Active {
+		t.Errorf("not valid introspection %v, %v, expected not active token", introspection, err)
+	}
+
+	_, err = s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{UserId: "1", TenantId: "unknown"})
+	if !errors.Is(err, ErrUnknownTenant) {
+		t.Errorf("not valid error %v, expected %v", err, ErrUnknownTenant)
+	}
+}
+
+func TestAlgorithms(t *testing.T) {
+	algorithms := []string{config.ALGORITHM_RS256, config.ALGORITHM_PS256, config.ALGORITHM_ES256, config.ALGORITHM_EdDSA}
+
+	for _, algorithm := range algorithms {
+		t.Run(algorithm, func(t *testing.T) {
+			cfg := newTestConfig(t)
+			watchTestConfig(t, cfg, strings.Replace(testConfig, "algorithm: RS256", "algorithm: "+algorithm, 1))
+			s := newTestServerWithConfig(t, cfg)
+			ctx := context.Background()
+
+			tokens := s.mustCreateTokens(t)
+			for _, token := range []string{tokens.AccessToken, tokens.RefreshToken} {
+				parsed, _, err := jwt.NewParser().ParseUnverified(token, jwt.MapClaims{})
+				if err != nil {
+					t.Fatal(err)
+				}
+				if parsed.Method.Alg() != algorithm {
+					t.Errorf("not valid alg %q, expected %q", parsed.Method.Alg(), algorithm)
+				}
+			}
+
+			result, err := s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken})
+			if err != nil {
+				t.Fatal(err)
+			}
+			if result.UserId != "1" {
+				t.Errorf("not valid user id %q, expected %q", result.UserId, "1")
+			}
+
+			_, err = s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+			if err != nil {
+				t.Errorf("refresh tokens: not expected error %v", err)
+			}
+		})
+	}
+}
+
+func TestAlgorithmMismatch(t *testing.T) {
+	s := newTestServer(t)
+	ctx := context.Background()
+	key := s.mustTenant(t, config.DEFAULT_Tenant).keys.Active()
+
+	sign := func(method jwt.SigningMethod, signer any) string {
+		claims := AccessClaims{
+			UUID: "uuid",
+			RegisteredClaims: jwt.RegisteredClaims{
+				ExpiresAt: jwt.NewNumericDate(time.Now().Add(time.Minute)),
+				Issuer:    "authentication",
+				Subject:   "user",
+				Audience:  jwt.ClaimStrings{"http://localhost:8080"},
+			},
+		}
+		token := jwt.NewWithClaims(method, claims)
+		token.Header["kid"] = key.ID
+		signed, err := token.SignedString(signer)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return signed
+	}
+
+	publicDER, err := x509.MarshalPKIXPublicKey(key.Public)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name  string
+		token string
+	}{
+		// the same RSA key, but other algorithm
+		{name: "PS256 under RS256 key", token: sign(jwt.SigningMethodPS256, key.Signer)},
+		// public key is known to anyone
+		{name: "HS256 with public key as secret", token: sign(jwt.SigningMethodHS256, publicDER)},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			_, err := s.parseAccessToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), test.token)
+			if !errors.Is(err, jwt.ErrTokenSignatureInvalid) {
+				t.Errorf("not valid error %v, expected %v", err, jwt.ErrTokenSignatureInvalid)
+			}
+		})
+	}
+}
+
+func TestTenantClaim(t *testing.T) {
+	cfg := newTestConfig(t)
+	watchTestConfig(t, cfg, testConfig+`
+tenants:
+  shop:
+    issuer: shop
+`)
+	s := newTestServerWithConfig(t, cfg)
+	ctx := context.Background()
+
+	// token signed by keys of default tenant, but issued for other tenant
+	forged := *s.mustTenant(t, config.DEFAULT_Tenant)
+	forged.id = "shop"
+	token, err := s.makeAccessToken(ctx, &forged, "uuid", nil, time.Now().Add(time.Minute))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	_, err = s.parseAccessToken(ctx, s.mustTenant(t, config.DEFAULT_Tenant), token)
+	expected := fmt.Sprintf(ERROR_TenantMismatch, "shop", config.DEFAULT_Tenant)
+	if err == nil || err.Error() != expected {
+		t.Errorf("not valid error %v, expected %q", err, expected)
+	}
+}
+
+func TestLogsWithoutTokens(t *testing.T) {
+	log := logger.New()
+	log.Out = io.Discard
+	log.SetRedactedClaims([]string{"email"})
+	hook := test.NewLocal(&log.Logger)
+	s := newTestServerWithLogger(t, newTestConfig(t), log)
+	ctx := context.Background()
+
+	tokens, err := s.CreateTokens(ctx, &jwt_gRPC.CreateTokensRequest{
+		UserId:     "1",
+		UserClaims: map[string]string{"email": "user@example.com", "role": "admin"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	refreshed, err := s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// reused and not valid tokens are logged with errors
+	s.RefreshTokens(ctx, &jwt_gRPC.RefreshTokensRequest{RefreshToken: tokens.RefreshToken})
+	s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: tokens.AccessToken})
+	s.GetUserId(ctx, &jwt_gRPC.GetUserIdRequest{AccessToken: refreshed.AccessToken})
+	s.CheckTokenExistence(ctx, &jwt_gRPC.CheckTokenExistenceRequest{AccessToken: &refreshed.AccessToken, RefreshToken: &refreshed.RefreshToken})
+	s.GetSession(ctx, &jwt_gRPC.GetSessionRequest{AccessToken: refreshed.AccessToken})
+	s.IntrospectToken(ctx, &jwt_gRPC.IntrospectTokenRequest{Token: refreshed.RefreshToken})
+	s.RevokeToken(ctx, &jwt_gRPC.RevokeTokenRequest{Token: refreshed.AccessToken})
+	s.RevokeTokens(ctx, &jwt_gRPC.RevokeTokensRequest{RefreshToken: refreshed.RefreshToken})
+
+	secrets := []string{tokens.AccessToken, tokens.RefreshToken, refreshed.AccessToken, refreshed.RefreshToken, "user@example.com"}
+	entries := hook.AllEntries()
+	if len(entries) == 0 {
+		t.Fatal("no log entries")
+	}
+	for _, entry := range entries {
+		line, err := entry.String()
+		if err != nil {
+			t.Fatal(err)
+		}
+		for _, secret := range secrets {
+			if strings.Contains(line, secret) {
+				t.Errorf("secret %q is logged: %s", secret, line)
+			}
+		}
+	}
+}
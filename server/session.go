@@ -0,0 +1,258 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"net/netip"
+	"strings"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/storage"
+	"github.com/sirupsen/logrus"
+	"go.opentelemetry.io/otel"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+)
+
+const (
+	EVENT_RefreshTokenReuse = "refresh_token_reuse"
+
+	MD_ForwardedFor = "x-forwarded-for"
+	MD_UserAgent    = "user-agent"
+)
+
+// Session is stored as value of access and refresh tokens. UUIDs link access
+// token to its refresh token pair and back.
+type Session struct {
+	UserID      string    `json:"user_id"`
+	FamilyID    string    `json:"family,omitempty"`
+	AccessUUID  string    `json:"access_uuid,omitempty"`
+	RefreshUUID string    `json:"refresh_uuid,omitempty"`
+	IP          string    `json:"ip,omitempty"`
+	UserAgent   string    `json:"user_agent,omitempty"`
+	DeviceName  string    `json:"device_name,omitempty"`
+	CreatedAt   time.Time `json:"created_at"`
+	RefreshedAt time.Time `json:"refreshed_at"`
+}
+
+// Family is a chain of refresh tokens rotated from one CreateTokens call.
+// Only the last issued pair of the family is valid. Family is a user session.
+type Family struct {
+	Session
+	IssuedAt         time.Time `json:"issued_at"`
+	AccessExpiresAt  time.Time `json:"access_expires_at"`
+	RefreshExpiresAt time.Time `json:"refresh_expires_at"`
+}
+
+// newSession makes session from request metadata. IP and user agent which
+// are not provided by caller are taken from gRPC peer and metadata.
+func newSession(ctx context.Context, userId string, meta *jwt_gRPC.SessionMetadata, trusted func(netip.Addr) bool) *Session {
+	session := &Session{
+		UserID:     userId,
+		IP:         meta.GetIp(),
+		UserAgent:  meta.GetUserAgent(),
+		DeviceName: meta.GetDeviceName(),
+		CreatedAt:  time.Now(),
+	}
+
+	md, _ := metadata.FromIncomingContext(ctx)
+	if session.IP == "" {
+		session.IP = clientIP(ctx, md, trusted)
+	}
+
+	if session.UserAgent == "" {
+		if ua := md.Get(MD_UserAgent); len(ua) > 0 {
+			session.UserAgent = ua[0]
+		}
+	}
+
+	return session
+}
+
+// clientIP returns address of gRPC peer. If peer is a trusted proxy, the
+// right-most address of X-Forwarded-For which is not a trusted proxy is
+// returned, because addresses on the left are set by client and can be
+// spoofed.
+func clientIP(ctx context.Context, md metadata.MD, trusted func(netip.Addr) bool) string {
+	p, ok := peer.FromContext(ctx)
+	if !ok {
+		return ""
+	}
+	addrPort, err := netip.ParseAddrPort(p.Addr.String())
+	if err != nil {
+		return ""
+	}
+
+	ip := addrPort.Addr().Unmap()
+	hops := strings.Split(strings.Join(md.Get(MD_ForwardedFor), ","), ",")
+	for i := len(hops) - 1; i >= 0 && trusted(ip); i-- {
+		hop, err := netip.ParseAddr(strings.TrimSpace(hops[i]))
+		if err != nil {
+			break
+		}
+		ip = hop.Unmap()
+	}
+
+	return ip.String()
+}
+
+// decodeSession decodes value of token. Tokens stored before sessions were
+// added have only user id as value.
+func decodeSession(value string) *Session {
+	var session Session
+	err := json.Unmarshal([]byte(value), &session)
+	if err != nil || session.UserID == "" {
+		return &Session{UserID: value}
+	}
+	return &session
+}
+
+func (s *Server) getSession(ctx context.Context, key string) (*Session, error) {
+	value, err := s.store.Get(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	return decodeSession(value), nil
+}
+
+// sessionResponse makes gRPC session from family.
+func sessionResponse(id string, family *Family) *jwt_gRPC.Session {
+	return &jwt_gRPC.Session{
+		Id:               id,
+		UserId:           family.UserID,
+		IssuedAt:         unix(family.IssuedAt),
+		AccessExpiresAt:  unix(family.AccessExpiresAt),
+		RefreshExpiresAt: unix(family.RefreshExpiresAt),
+		CreatedAt:        unix(family.CreatedAt),
+		RefreshedAt:      unix(family.RefreshedAt),
+		Metadata: &jwt_gRPC.SessionMetadata{
+			Ip:         family.IP,
+			UserAgent:  family.UserAgent,
+			DeviceName: family.DeviceName,
+		},
+	}
+}
+
+func unix(t time.Time) int64 {
+	if t.IsZero() {
+		return 0
+	}
+	return t.Unix()
+}
+
+// familyOps saves family and adds it to user sessions. Sessions set lives
+// as long as the latest of its families.
+func (s *Server) familyOps(t *tenant, id string, family *Family) ([]storage.Op, error) {
+	b, err := json.Marshal(family)
+	if err != nil {
+		return nil, err
+	}
+
+	ttl := time.Until(family.RefreshExpiresAt)
+	return []storage.Op{
+		storage.SetOp(t.schema.Family(id), string(b), ttl),
+		storage.SAddOp(t.schema.User(family.UserID), id, ttl),
+	}, nil
+}
+
+func (s *Server) getFamily(ctx context.Context, t *tenant, id string) (*Family, error) {
+	value, err := s.store.Get(ctx, t.schema.Family(id))
+	if err != nil {
+		return nil, err
+	}
+
+	var family Family
+	err = json.Unmarshal([]byte(value), &family)
+	if err != nil {
+		return nil, err
+	}
+
+	return &family, nil
+}
+
+// detectReuse checks if refresh token was already rotated. Reuse of rotated
+// token means that it was stolen, so the whole family is revoked as described
+// in OAuth 2.0 Security Best Current Practice.
+func (s *Server) detectReuse(ctx context.Context, log *logrus.Entry, t *tenant, familyID string, refreshUUID string) (bool, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "detectReuse")
+	defer span.End()
+
+	// tokens issued before families were added
+	if familyID == "" {
+		return false, nil
+	}
+
+	family, err := s.getFamily(newCtx, t, familyID)
+	if err != nil {
+		if err == storage.ErrNotFound {
+			return false, nil
+		}
+		return false, err
+	}
+
+	if family.RefreshUUID == refreshUUID {
+		return false, nil
+	}
+
+	err = s.revokeFamily(newCtx, t, familyID, family)
+	if err != nil {
+		return true, err
+	}
+
+	log.WithFields(logrus.Fields{
+		"event":        EVENT_RefreshTokenReuse,
+		"tenant":       t.id,
+		"family":       familyID,
+		"user_id":      family.UserID,
+		"refresh_uuid": refreshUUID,
+	}).Warn("refresh token reuse detected, token family revoked")
+
+	return true, nil
+}
+
+// userFamilies returns active families of user. Expired and revoked families
+// are removed from user sessions.
+func (s *Server) userFamilies(ctx context.Context, t *tenant, userId string) (map[string]*Family, error) {
+	newCtx, span := otel.Tracer(TRACE_NAME).Start(ctx, "userFamilies")
+	defer span.End()
+
+	key := t.schema.User(userId)
+	ids, err := s.store.SMembers(newCtx, key)
+	if err != nil {
+		return nil, err
+	}
+
+	families := make(map[string]*Family, len(ids))
+	for _, id := range ids {
+		family, err := s.getFamily(newCtx, t, id)
+		if err == storage.ErrNotFound {
+			err = s.store.SRem(newCtx, key, id)
+			if err != nil {
+				return nil, err
+			}
+			continue
+		}
+		if err != nil {
+			return nil, err
+		}
+		families[id] = family
+	}
+
+	return families, nil
+}
+
+// revokeFamily deletes the last issued pair of family and family itself.
+func (s *Server) revokeFamily(ctx context.Context, t *tenant, id string, family *Family) error {
+	return s.store.Exec(ctx, s.revokeFamilyOps(t, id, family)...)
+}
+
+func (s *Server) revokeFamilyOps(t *tenant, id string, family *Family) []storage.Op {
+	return []storage.Op{
+		storage.DelOp(t.schema.Access(family.AccessUUID)),
+		storage.DelOp(t.schema.Refresh(family.RefreshUUID)),
+		storage.DelOp(t.schema.Family(id)),
+		storage.SRemOp(t.schema.User(family.UserID), id),
+	}
+}
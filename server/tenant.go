@@ -0,0 +1,71 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/keyring"
+	"github.com/Moranilt/jwt-http2/storage"
+)
+
+const ERROR_TenantMismatch = "token of tenant %q is not valid for tenant %q"
+
+var (
+	// ErrUnknownTenant is returned for tenant which is not configured.
+	ErrUnknownTenant = errors.New("unknown tenant")
+	// ErrTenantMismatch is returned for token issued for other tenant.
+	ErrTenantMismatch = errors.New("tenant mismatch")
+)
+
+// tenantMismatchError describes claimed and expected tenants and matches
+// ErrTenantMismatch.
+type tenantMismatchError struct {
+	claimed, expected string
+}
+
+func (e *tenantMismatchError) Error() string {
+	return fmt.Sprintf(ERROR_TenantMismatch, e.claimed, e.expected)
+}
+
+func (e *tenantMismatchError) Is(target error) bool {
+	return target == ErrTenantMismatch
+}
+
+// tenant is a config, signing keys and key schema of one tenant. Empty id is
+// a default tenant.
+type tenant struct {
+	id     string
+	config *config.AppConfig[time.Duration]
+	keys   *keyring.Keyring
+	schema storage.KeySchema
+}
+
+// tenant returns tenant of request by id.
+func (s *Server) tenant(id string) (*tenant, error) {
+	cfg, ok := s.config.Load().Tenant(id)
+	if !ok {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, id)
+	}
+
+	keys, ok := s.keys.Keyring(id)
+	if !ok || keys.Active() == nil {
+		return nil, fmt.Errorf("%w %q", ErrUnknownTenant, id)
+	}
+
+	return &tenant{
+		id:     id,
+		config: cfg,
+		keys:   keys,
+		schema: s.schema.Tenant(id),
+	}, nil
+}
+
+// checkTenant rejects token issued for other tenant.
+func (t *tenant) checkTenant(claimed string) error {
+	if claimed != t.id {
+		return &tenantMismatchError{claimed: claimed, expected: t.id}
+	}
+	return nil
+}
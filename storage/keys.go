@@ -0,0 +1,63 @@
+package storage
+
+import "strings"
+
+const (
+	DEFAULT_KeyPrefix = "auth"
+
+	KEY_Access  = "access"
+	KEY_Refresh = "refresh"
+	KEY_Family  = "family"
+	KEY_User    = "user"
+	KEY_Tenant  = "tenant"
+)
+
+// KeySchema makes keys of stored data as <prefix>:<type>:<id>. Common prefix
+// allows to share one Redis between services.
+type KeySchema struct {
+	Prefix string
+}
+
+func NewKeySchema(prefix string) KeySchema {
+	if prefix == "" {
+		prefix = DEFAULT_KeyPrefix
+	}
+	return KeySchema{
+		Prefix: prefix,
+	}
+}
+
+// Tenant returns schema of tenant keys <prefix>:tenant:<tenant>:<type>:<id>.
+// Keys of default tenant are not changed.
+func (k KeySchema) Tenant(id string) KeySchema {
+	if id == "" {
+		return k
+	}
+	return KeySchema{
+		Prefix: k.key(KEY_Tenant, id),
+	}
+}
+
+// Access is a key of access token session.
+func (k KeySchema) Access(uuid string) string {
+	return k.key(KEY_Access, uuid)
+}
+
+// Refresh is a key of refresh token session.
+func (k KeySchema) Refresh(uuid string) string {
+	return k.key(KEY_Refresh, uuid)
+}
+
+// Family is a key of token family.
+func (k KeySchema) Family(id string) string {
+	return k.key(KEY_Family, id)
+}
+
+// User is a key of set with family ids of user.
+func (k KeySchema) User(userId string) string {
+	return k.key(KEY_User, userId)
+}
+
+func (k KeySchema) key(kind string, id string) string {
+	return strings.Join([]string{k.Prefix, kind, id}, ":")
+}
@@ -0,0 +1,218 @@
+package storage
+
+import (
+	"context"
+	"sync"
+	"time"
+)
+
+// MEMORY_EvictionInterval is an interval of removing expired keys by Run.
+const MEMORY_EvictionInterval = time.Minute
+
+type entry struct {
+	value     string
+	set       map[string]struct{}
+	expiresAt time.Time
+}
+
+func (e *entry) expired(now time.Time) bool {
+	return !e.expiresAt.IsZero() && !now.Before(e.expiresAt)
+}
+
+// Memory is an in-memory SessionStore for tests and single instance setups.
+// Expired keys are not visible and are removed by Run.
+type Memory struct {
+	mu      sync.Mutex
+	entries map[string]*entry
+	now     func() time.Time
+}
+
+func NewMemory() *Memory {
+	return &Memory{
+		entries: make(map[string]*entry),
+		now:     time.Now,
+	}
+}
+
+// Run removes expired keys every interval until ctx is done.
+func (m *Memory) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			m.evict()
+		}
+	}
+}
+
+func (m *Memory) evict() {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	now := m.now()
+	for key, e := range m.entries {
+		if e.expired(now) {
+			delete(m.entries, key)
+		}
+	}
+}
+
+// get returns not expired entry. Must be called with locked mu.
+func (m *Memory) get(key string) (*entry, bool) {
+	e, ok := m.entries[key]
+	if !ok {
+		return nil, false
+	}
+	if e.expired(m.now()) {
+		delete(m.entries, key)
+		return nil, false
+	}
+	return e, true
+}
+
+func (m *Memory) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.set(key, value, ttl)
+	return nil
+}
+
+func (m *Memory) set(key string, value string, ttl time.Duration) {
+	m.entries[key] = &entry{
+		value:     value,
+		expiresAt: m.expiresAt(ttl),
+	}
+}
+
+func (m *Memory) Get(ctx context.Context, key string) (string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key)
+	if !ok || e.set != nil {
+		return "", ErrNotFound
+	}
+	return e.value, nil
+}
+
+func (m *Memory) Exists(ctx context.Context, keys ...string) (int64, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	var count int64
+	for _, key := range keys {
+		if _, ok := m.get(key); ok {
+			count++
+		}
+	}
+	return count, nil
+}
+
+func (m *Memory) Del(ctx context.Context, keys ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, key := range keys {
+		delete(m.entries, key)
+	}
+	return nil
+}
+
+func (m *Memory) SAdd(ctx context.Context, key string, member string, ttl time.Duration) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.sadd(key, member, ttl)
+	return nil
+}
+
+func (m *Memory) sadd(key string, member string, ttl time.Duration) {
+	e, ok := m.get(key)
+	if !ok || e.set == nil {
+		e = &entry{set: make(map[string]struct{})}
+		m.entries[key] = e
+	}
+
+	e.set[member] = struct{}{}
+	if expiresAt := m.expiresAt(ttl); e.expiresAt.Before(expiresAt) {
+		e.expiresAt = expiresAt
+	}
+}
+
+func (m *Memory) SMembers(ctx context.Context, key string) ([]string, error) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	e, ok := m.get(key)
+	if !ok {
+		return []string{}, nil
+	}
+
+	members := make([]string, 0, len(e.set))
+	for member := range e.set {
+		members = append(members, member)
+	}
+	return members, nil
+}
+
+func (m *Memory) SRem(ctx context.Context, key string, members ...string) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.srem(key, members...)
+	return nil
+}
+
+func (m *Memory) srem(key string, members ...string) {
+	e, ok := m.get(key)
+	if !ok {
+		return
+	}
+
+	for _, member := range members {
+		delete(e.set, member)
+	}
+	if len(e.set) == 0 {
+		delete(m.entries, key)
+	}
+}
+
+func (m *Memory) Exec(ctx context.Context, ops ...Op) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	for _, op := range ops {
+		if op.Kind != OP_Consume {
+			continue
+		}
+		if _, ok := m.get(op.Key); !ok {
+			return ErrNotFound
+		}
+	}
+
+	for _, op := range ops {
+		switch op.Kind {
+		case OP_Set:
+			m.set(op.Key, op.Value, op.TTL)
+		case OP_Del, OP_Consume:
+			delete(m.entries, op.Key)
+		case OP_SAdd:
+			m.sadd(op.Key, op.Value, op.TTL)
+		case OP_SRem:
+			m.srem(op.Key, op.Value)
+		}
+	}
+	return nil
+}
+
+func (m *Memory) expiresAt(ttl time.Duration) time.Time {
+	if ttl <= 0 {
+		return time.Time{}
+	}
+	return m.now().Add(ttl)
+}
@@ -0,0 +1,224 @@
+package storage
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"strings"
+	"time"
+
+	"github.com/google/uuid"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	// legacy layout of keys without prefix
+	LEGACY_TokenPattern  = "????????-????-????-????-????????????"
+	LEGACY_FamilyPattern = "family:" + LEGACY_TokenPattern
+	LEGACY_UserPattern   = "user:*"
+
+	MIGRATE_ScanCount = 1000
+	MIGRATE_Retries   = 5
+
+	ERROR_MigrateCluster = "migration of keys is not supported in cluster mode"
+)
+
+// MigrationReport is a number of moved keys of every type.
+type MigrationReport struct {
+	Access  int
+	Refresh int
+	Family  int
+	User    int
+	Skipped int
+}
+
+// MigrateKeys moves keys of legacy layout with bare UUIDs into schema keeping
+// their values and TTLs, so issued tokens stay valid. Session values without
+// token uuids can not be linked to type of token and are copied to both access
+// and refresh keys. With dryRun keys are only counted.
+//
+// Every key is moved in transaction, so migration can run while service is
+// working and can be restarted. Legacy keys are in different slots of cluster,
+// so cluster is not supported.
+func MigrateKeys(ctx context.Context, client redis.UniversalClient, schema KeySchema, dryRun bool) (*MigrationReport, error) {
+	report := new(MigrationReport)
+	if _, ok := client.(*redis.ClusterClient); ok {
+		return report, errors.New(ERROR_MigrateCluster)
+	}
+
+	err := scan(ctx, client, LEGACY_TokenPattern, func(key string) error {
+		if _, err := uuid.Parse(key); err != nil {
+			report.Skipped++
+			return nil
+		}
+		return moveString(ctx, client, key, dryRun, report, func(value string) ([]string, func()) {
+			return tokenKeys(schema, key, value, report)
+		})
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = scan(ctx, client, LEGACY_FamilyPattern, func(key string) error {
+		id := strings.TrimPrefix(key, "family:")
+		if _, err := uuid.Parse(id); err != nil {
+			report.Skipped++
+			return nil
+		}
+		return moveString(ctx, client, key, dryRun, report, func(string) ([]string, func()) {
+			return []string{schema.Family(id)}, func() { report.Family++ }
+		})
+	})
+	if err != nil {
+		return report, err
+	}
+
+	err = scan(ctx, client, LEGACY_UserPattern, func(key string) error {
+		return moveUserSessions(ctx, client, key, schema, dryRun, report)
+	})
+	return report, err
+}
+
+func scan(ctx context.Context, client redis.UniversalClient, pattern string, fn func(key string) error) error {
+	iter := client.Scan(ctx, 0, pattern, MIGRATE_ScanCount).Iterator()
+	for iter.Next(ctx) {
+		err := fn(iter.Val())
+		if err != nil {
+			return err
+		}
+	}
+	return iter.Err()
+}
+
+// tokenKeys returns new keys of token session and function to count them.
+func tokenKeys(schema KeySchema, key string, value string, report *MigrationReport) ([]string, func()) {
+	var session struct {
+		AccessUUID  string `json:"access_uuid"`
+		RefreshUUID string `json:"refresh_uuid"`
+	}
+	// value may be bare userId of the first versions
+	_ = json.Unmarshal([]byte(value), &session)
+
+	switch key {
+	case session.AccessUUID:
+		return []string{schema.Access(key)}, func() { report.Access++ }
+	case session.RefreshUUID:
+		return []string{schema.Refresh(key)}, func() { report.Refresh++ }
+	default:
+		return []string{schema.Access(key), schema.Refresh(key)}, func() {
+			report.Access++
+			report.Refresh++
+		}
+	}
+}
+
+// watch runs fn in optimistic transaction on key. Transaction is retried if
+// key was changed.
+func watch(ctx context.Context, client redis.UniversalClient, key string, fn func(tx *redis.Tx) error) error {
+	var err error
+	for i := 0; i < MIGRATE_Retries; i++ {
+		err = client.Watch(ctx, fn, key)
+		if err != redis.TxFailedErr {
+			return err
+		}
+	}
+	return err
+}
+
+// moveString copies value of key to keys returned by newKeys and deletes key.
+func moveString(ctx context.Context, client redis.UniversalClient, key string, dryRun bool, report *MigrationReport, newKeys func(value string) ([]string, func())) error {
+	return watch(ctx, client, key, func(tx *redis.Tx) error {
+		if tx.Type(ctx, key).Val() != "string" {
+			report.Skipped++
+			return nil
+		}
+
+		value, err := tx.Get(ctx, key).Result()
+		if err == redis.Nil {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+		ttl, err := tx.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		keys, count := newKeys(value)
+		if dryRun {
+			count()
+			return nil
+		}
+
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			for _, newKey := range keys {
+				pipe.Set(ctx, newKey, value, keepTTL(ttl))
+			}
+			pipe.Del(ctx, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		count()
+		return nil
+	})
+}
+
+// moveUserSessions moves set of user families. Sets of other services with
+// the same legacy key are skipped by type of members.
+func moveUserSessions(ctx context.Context, client redis.UniversalClient, key string, schema KeySchema, dryRun bool, report *MigrationReport) error {
+	return watch(ctx, client, key, func(tx *redis.Tx) error {
+		if tx.Type(ctx, key).Val() != "set" {
+			report.Skipped++
+			return nil
+		}
+
+		members, err := tx.SMembers(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+		for _, member := range members {
+			if _, err := uuid.Parse(member); err != nil {
+				report.Skipped++
+				return nil
+			}
+		}
+		ttl, err := tx.PTTL(ctx, key).Result()
+		if err != nil {
+			return err
+		}
+
+		if dryRun || len(members) == 0 {
+			report.User++
+			return nil
+		}
+
+		newKey := schema.User(strings.TrimPrefix(key, "user:"))
+		_, err = tx.TxPipelined(ctx, func(pipe redis.Pipeliner) error {
+			pipe.SAdd(ctx, newKey, toAny(members)...)
+			if ttl > 0 {
+				pipe.PExpire(ctx, newKey, ttl)
+			}
+			pipe.Del(ctx, key)
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+
+		report.User++
+		return nil
+	})
+}
+
+// keepTTL converts PTTL result to expiration of SET. Key without TTL has
+// negative PTTL.
+func keepTTL(ttl time.Duration) time.Duration {
+	if ttl < 0 {
+		return 0
+	}
+	return ttl
+}
@@ -0,0 +1,113 @@
+package storage
+
+import (
+	"context"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+const (
+	testAccessUUID  = "6f1c7a4e-8a9b-4c1d-9e2f-0a1b2c3d4e5f"
+	testRefreshUUID = "0d2e3f4a-5b6c-4d7e-8f9a-1b2c3d4e5f60"
+	testLegacyUUID  = "a1b2c3d4-e5f6-4a7b-8c9d-0e1f2a3b4c5d"
+	testFamilyID    = "f0e1d2c3-b4a5-4968-8776-655443322110"
+)
+
+func newLegacyRedis(t *testing.T) (*miniredis.Miniredis, *redis.Client) {
+	mr := miniredis.RunT(t)
+	session := `{"user_id":"1","access_uuid":"` + testAccessUUID + `","refresh_uuid":"` + testRefreshUUID + `"}`
+
+	mr.Set(testAccessUUID, session)
+	mr.SetTTL(testAccessUUID, 15*time.Minute)
+	mr.Set(testRefreshUUID, session)
+	mr.SetTTL(testRefreshUUID, 7*24*time.Hour)
+	mr.Set(testLegacyUUID, "2")
+	mr.SetTTL(testLegacyUUID, time.Hour)
+	mr.Set("family:"+testFamilyID, `{"user_id":"1"}`)
+	mr.SetTTL("family:"+testFamilyID, 7*24*time.Hour)
+	mr.SAdd("user:1", testFamilyID)
+	mr.SetTTL("user:1", 7*24*time.Hour)
+
+	// keys of other services
+	mr.Set("zzzzzzzz-zzzz-zzzz-zzzz-zzzzzzzzzzzz", "value")
+	mr.SAdd("user:other", "not uuid")
+	mr.Set("other:key", "value")
+
+	return mr, redis.NewClient(&redis.Options{Addr: mr.Addr()})
+}
+
+func TestMigrateKeys(t *testing.T) {
+	mr, client := newLegacyRedis(t)
+	schema := NewKeySchema("auth")
+
+	report, err := MigrateKeys(context.Background(), client, schema, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := MigrationReport{Access: 2, Refresh: 2, Family: 1, User: 1, Skipped: 2}
+	if *report != expected {
+		t.Errorf("not valid report %+v, expected %+v", *report, expected)
+	}
+
+	ttls := map[string]time.Duration{
+		schema.Access(testAccessUUID):   15 * time.Minute,
+		schema.Refresh(testRefreshUUID): 7 * 24 * time.Hour,
+		schema.Access(testLegacyUUID):   time.Hour,
+		schema.Refresh(testLegacyUUID):  time.Hour,
+		schema.Family(testFamilyID):     7 * 24 * time.Hour,
+		schema.User("1"):                7 * 24 * time.Hour,
+	}
+	for key, ttl := range ttls {
+		if !mr.Exists(key) {
+			t.Errorf("key %q was not migrated", key)
+			continue
+		}
+		if mr.TTL(key) != ttl {
+			t.Errorf("not valid ttl %s of %q, expected %s", mr.TTL(key), key, ttl)
+		}
+	}
+
+	for _, key := range []string{testAccessUUID, testRefreshUUID, testLegacyUUID, "family:" + testFamilyID, "user:1"} {
+		if mr.Exists(key) {
+			t.Errorf("legacy key %q was not deleted", key)
+		}
+	}
+	for _, key := range []string{"zzzzzzzz-zzzz-zzzz-zzzz-zzzzzzzzzzzz", "user:other", "other:key"} {
+		if !mr.Exists(key) {
+			t.Errorf("key %q of other service was migrated", key)
+		}
+	}
+	if mr.Exists(schema.Refresh(testAccessUUID)) || mr.Exists(schema.Access(testRefreshUUID)) {
+		t.Error("linked session was copied to key of other type")
+	}
+
+	report, err = MigrateKeys(context.Background(), client, schema, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if *report != (MigrationReport{Skipped: 2}) {
+		t.Errorf("keys were migrated twice: %+v", *report)
+	}
+}
+
+func TestMigrateKeysDryRun(t *testing.T) {
+	mr, client := newLegacyRedis(t)
+	keys := len(mr.Keys())
+
+	report, err := MigrateKeys(context.Background(), client, NewKeySchema("auth"), true)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := MigrationReport{Access: 2, Refresh: 2, Family: 1, User: 1, Skipped: 2}
+	if *report != expected {
+		t.Errorf("not valid report %+v, expected %+v", *report, expected)
+	}
+	if len(mr.Keys()) != keys || !mr.Exists(testAccessUUID) {
+		t.Error("keys were changed by dry run")
+	}
+}
@@ -0,0 +1,138 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/redis/go-redis/v9"
+)
+
+type Redis struct {
+	client redis.UniversalClient
+}
+
+func NewRedis(client redis.UniversalClient) *Redis {
+	return &Redis{
+		client: client,
+	}
+}
+
+func (r *Redis) Set(ctx context.Context, key string, value string, ttl time.Duration) error {
+	return unavailable(r.client.Set(ctx, key, value, ttl).Err())
+}
+
+func (r *Redis) Get(ctx context.Context, key string) (string, error) {
+	value, err := r.client.Get(ctx, key).Result()
+	if err == redis.Nil {
+		return "", ErrNotFound
+	}
+	return value, unavailable(err)
+}
+
+func (r *Redis) Exists(ctx context.Context, keys ...string) (int64, error) {
+	count, err := r.client.Exists(ctx, keys...).Result()
+	return count, unavailable(err)
+}
+
+func (r *Redis) Del(ctx context.Context, keys ...string) error {
+	return unavailable(r.client.Del(ctx, keys...).Err())
+}
+
+func (r *Redis) SAdd(ctx context.Context, key string, member string, ttl time.Duration) error {
+	err := r.client.SAdd(ctx, key, member).Err()
+	if err != nil {
+		return unavailable(err)
+	}
+
+	current, err := r.client.TTL(ctx, key).Result()
+	if err != nil {
+		return unavailable(err)
+	}
+	if current < ttl {
+		return unavailable(r.client.Expire(ctx, key, ttl).Err())
+	}
+
+	return nil
+}
+
+func (r *Redis) SMembers(ctx context.Context, key string) ([]string, error) {
+	members, err := r.client.SMembers(ctx, key).Result()
+	return members, unavailable(err)
+}
+
+func (r *Redis) SRem(ctx context.Context, key string, members ...string) error {
+	return unavailable(r.client.SRem(ctx, key, toAny(members)...).Err())
+}
+
+// unavailable wraps error of Redis with ErrUnavailable. Errors of context
+// are returned as is.
+func unavailable(err error) error {
+	if err == nil || errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+		return err
+	}
+	return fmt.Errorf("%w: %v", ErrUnavailable, err)
+}
+
+func toAny(values []string) []any {
+	result := make([]any, len(values))
+	for i, v := range values {
+		result[i] = v
+	}
+	return result
+}
+
+// execScript applies ops of Exec. Every op has one key in KEYS and kind, ttl
+// in milliseconds and value in ARGV.
+var execScript = redis.NewScript(`
+for i = 1, #KEYS do
+	if ARGV[i * 3 - 2] == "consume" and redis.call("EXISTS", KEYS[i]) == 0 then
+		return 0
+	end
+end
+
+for i = 1, #KEYS do
+	local kind, ttl, value = ARGV[i * 3 - 2], tonumber(ARGV[i * 3 - 1]), ARGV[i * 3]
+	if kind == "set" then
+		if ttl > 0 then
+			redis.call("SET", KEYS[i], value, "PX", ttl)
+		else
+			redis.call("SET", KEYS[i], value)
+		end
+	elseif kind == "del" or kind == "consume" then
+		redis.call("DEL", KEYS[i])
+	elseif kind == "sadd" then
+		redis.call("SADD", KEYS[i], value)
+		if ttl > 0 and redis.call("PTTL", KEYS[i]) < ttl then
+			redis.call("PEXPIRE", KEYS[i], ttl)
+		end
+	elseif kind == "srem" then
+		redis.call("SREM", KEYS[i], value)
+	end
+end
+
+return 1
+`)
+
+func (r *Redis) Exec(ctx context.Context, ops ...Op) error {
+	if len(ops) == 0 {
+		return nil
+	}
+
+	keys := make([]string, len(ops))
+	args := make([]any, 0, len(ops)*3)
+	for i, op := range ops {
+		keys[i] = op.Key
+		args = append(args, string(op.Kind), op.TTL.Milliseconds(), op.Value)
+	}
+
+	applied, err := execScript.Run(ctx, r.client, keys, args...).Int()
+	if err != nil {
+		return unavailable(err)
+	}
+	if applied == 0 {
+		return ErrNotFound
+	}
+	return nil
+}
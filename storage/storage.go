@@ -0,0 +1,75 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"time"
+)
+
+var (
+	ErrNotFound = errors.New("not found")
+	// ErrUnavailable wraps errors of store which is not reachable.
+	ErrUnavailable = errors.New("storage is unavailable")
+)
+
+// SessionStore keeps sessions of tokens by their keys. Values of keys and
+// members of sets are removed after ttl.
+type SessionStore interface {
+	// Set stores value by key for ttl.
+	Set(ctx context.Context, key string, value string, ttl time.Duration) error
+	// Get returns value of key or ErrNotFound.
+	Get(ctx context.Context, key string) (string, error)
+	// Exists returns number of existing keys.
+	Exists(ctx context.Context, keys ...string) (int64, error)
+	// Del removes keys. Missing keys are ignored.
+	Del(ctx context.Context, keys ...string) error
+	// SAdd adds member to set. Set lives at least ttl from now.
+	SAdd(ctx context.Context, key string, member string, ttl time.Duration) error
+	// SMembers returns members of set. Missing set is empty.
+	SMembers(ctx context.Context, key string) ([]string, error)
+	// SRem removes members from set.
+	SRem(ctx context.Context, key string, members ...string) error
+	// Exec applies all ops atomically. If any key of ConsumeOp does not
+	// exist, nothing is applied and ErrNotFound is returned.
+	Exec(ctx context.Context, ops ...Op) error
+}
+
+type opKind string
+
+const (
+	OP_Set     opKind = "set"
+	OP_Del     opKind = "del"
+	OP_Consume opKind = "consume"
+	OP_SAdd    opKind = "sadd"
+	OP_SRem    opKind = "srem"
+)
+
+// Op is a single write of Exec.
+type Op struct {
+	Kind  opKind
+	Key   string
+	Value string
+	TTL   time.Duration
+}
+
+func SetOp(key string, value string, ttl time.Duration) Op {
+	return Op{Kind: OP_Set, Key: key, Value: value, TTL: ttl}
+}
+
+func DelOp(key string) Op {
+	return Op{Kind: OP_Del, Key: key}
+}
+
+// ConsumeOp deletes key which must exist. It makes Exec a compare-and-swap:
+// of concurrent Exec calls consuming the same key only one is applied.
+func ConsumeOp(key string) Op {
+	return Op{Kind: OP_Consume, Key: key}
+}
+
+func SAddOp(key string, member string, ttl time.Duration) Op {
+	return Op{Kind: OP_SAdd, Key: key, Value: member, TTL: ttl}
+}
+
+func SRemOp(key string, member string) Op {
+	return Op{Kind: OP_SRem, Key: key, Value: member}
+}
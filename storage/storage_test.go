@@ -0,0 +1,281 @@
+package storage
+
+import (
+	"context"
+	"errors"
+	"sort"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/alicebob/miniredis/v2"
+	"github.com/redis/go-redis/v9"
+)
+
+type testStore struct {
+	SessionStore
+	// forward moves time of store
+	forward func(time.Duration)
+}
+
+func newTestStores(t *testing.T) map[string]testStore {
+	mr := miniredis.RunT(t)
+
+	memory := NewMemory()
+	now := time.Now()
+	memory.now = func() time.Time { return now }
+
+	return map[string]testStore{
+		"redis": {
+			SessionStore: NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr()})),
+			forward:      mr.FastForward,
+		},
+		"memory": {
+			SessionStore: memory,
+			forward:      func(d time.Duration) { now = now.Add(d) },
+		},
+	}
+}
+
+func TestSessionStore(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			err := store.Set(ctx, "a", "1", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.Set(ctx, "b", "2", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			value, err := store.Get(ctx, "a")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if value != "1" {
+				t.Errorf("not valid value %q, expected %q", value, "1")
+			}
+
+			_, err = store.Get(ctx, "c")
+			if err != ErrNotFound {
+				t.Errorf("not valid error %v, expected %v", err, ErrNotFound)
+			}
+
+			count, err := store.Exists(ctx, "a", "b", "c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 2 {
+				t.Errorf("not valid count %d, expected 2", count)
+			}
+
+			store.forward(2 * time.Minute)
+
+			_, err = store.Get(ctx, "a")
+			if err != ErrNotFound {
+				t.Errorf("expired key: not valid error %v, expected %v", err, ErrNotFound)
+			}
+
+			err = store.Del(ctx, "b", "c")
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, err = store.Exists(ctx, "a", "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 0 {
+				t.Errorf("not valid count %d after deletion, expected 0", count)
+			}
+		})
+	}
+}
+
+func TestSessionStoreSets(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			err := store.SAdd(ctx, "set", "a", time.Hour)
+			if err != nil {
+				t.Fatal(err)
+			}
+			// shorter ttl does not shorten life of set
+			err = store.SAdd(ctx, "set", "b", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+			err = store.SAdd(ctx, "set", "c", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.SRem(ctx, "set", "c", "d")
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			store.forward(2 * time.Minute)
+
+			members, err := store.SMembers(ctx, "set")
+			if err != nil {
+				t.Fatal(err)
+			}
+			sort.Strings(members)
+			if len(members) != 2 || members[0] != "a" || members[1] != "b" {
+				t.Errorf("not valid members %v, expected [a b]", members)
+			}
+
+			store.forward(time.Hour)
+
+			members, err = store.SMembers(ctx, "set")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(members) != 0 {
+				t.Errorf("expired set has members %v", members)
+			}
+		})
+	}
+}
+
+func TestExec(t *testing.T) {
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			err := store.Exec(ctx,
+				SetOp("a", "1", time.Minute),
+				SAddOp("set", "a", time.Minute),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			err = store.Exec(ctx,
+				ConsumeOp("missing"),
+				DelOp("a"),
+				SetOp("b", "2", time.Minute),
+			)
+			if err != ErrNotFound {
+				t.Fatalf("not valid error %v, expected %v", err, ErrNotFound)
+			}
+			count, err := store.Exists(ctx, "a", "b")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Fatalf("ops were applied with missing consumed key")
+			}
+
+			err = store.Exec(ctx,
+				ConsumeOp("a"),
+				SRemOp("set", "a"),
+				SetOp("b", "2", time.Minute),
+			)
+			if err != nil {
+				t.Fatal(err)
+			}
+			count, err = store.Exists(ctx, "a", "b", "set")
+			if err != nil {
+				t.Fatal(err)
+			}
+			if count != 1 {
+				t.Errorf("not valid count %d, expected 1", count)
+			}
+		})
+	}
+}
+
+func TestExecConsumeConcurrently(t *testing.T) {
+	const workers = 50
+
+	for name, store := range newTestStores(t) {
+		t.Run(name, func(t *testing.T) {
+			ctx := context.Background()
+
+			err := store.Set(ctx, "a", "1", time.Minute)
+			if err != nil {
+				t.Fatal(err)
+			}
+
+			var applied atomic.Int64
+			var wg sync.WaitGroup
+			for i := 0; i < workers; i++ {
+				wg.Add(1)
+				go func() {
+					defer wg.Done()
+					err := store.Exec(ctx, ConsumeOp("a"), SAddOp("winners", "a", time.Minute))
+					if err == nil {
+						applied.Add(1)
+					} else if err != ErrNotFound {
+						t.Error(err)
+					}
+				}()
+			}
+			wg.Wait()
+
+			if applied.Load() != 1 {
+				t.Errorf("key was consumed %d times, expected once", applied.Load())
+			}
+		})
+	}
+}
+
+func TestMemoryEviction(t *testing.T) {
+	ctx := context.Background()
+	memory := NewMemory()
+
+	err := memory.Set(ctx, "a", "1", time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = memory.Set(ctx, "b", "2", time.Hour)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, cancel := context.WithTimeout(ctx, 50*time.Millisecond)
+	defer cancel()
+	err = memory.Run(ctx, 5*time.Millisecond)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	memory.mu.Lock()
+	defer memory.mu.Unlock()
+	if _, ok := memory.entries["a"]; ok {
+		t.Error("expired key was not evicted")
+	}
+	if _, ok := memory.entries["b"]; !ok {
+		t.Error("not expired key was evicted")
+	}
+}
+
+func TestRedisUnavailable(t *testing.T) {
+	mr := miniredis.RunT(t)
+	store := NewRedis(redis.NewClient(&redis.Options{Addr: mr.Addr(), MaxRetries: -1}))
+	mr.Close()
+
+	ctx := context.Background()
+	_, err := store.Get(ctx, "key")
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("get: not valid error %v, expected %v", err, ErrUnavailable)
+	}
+
+	err = store.Exec(ctx, SetOp("key", "value", time.Minute))
+	if !errors.Is(err, ErrUnavailable) {
+		t.Errorf("exec: not valid error %v, expected %v", err, ErrUnavailable)
+	}
+
+	ctx, cancel := context.WithCancel(ctx)
+	cancel()
+	_, err = store.Get(ctx, "key")
+	if errors.Is(err, ErrUnavailable) {
+		t.Errorf("canceled get: not valid error %v", err)
+	}
+}
@@ -0,0 +1,202 @@
+// Package tlscert keeps TLS certificate of server up to date. Certificate,
+// its key and CA of clients are reloaded from Source without restart.
+package tlscert
+
+import (
+	"bytes"
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	"os"
+	"sync/atomic"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/logger"
+)
+
+const (
+	CLIENT_AuthNone     = "none"
+	CLIENT_AuthOptional = "optional"
+	CLIENT_AuthRequire  = "require"
+
+	// DEFAULT_ReloadInterval is an interval of checking source for new
+	// certificates
+	DEFAULT_ReloadInterval = time.Minute
+
+	ERROR_NoClientCA      = "client CA is required in %q client auth mode"
+	ERROR_NotValidCA      = "not valid client CA: no certificates found"
+	ERROR_NotValidAuth    = "not valid client auth %q. Expected none, optional or require"
+	ERROR_EmptyCertOrKey  = "certificate and key are required"
+	ERROR_NotLoadedConfig = "TLS certificates are not loaded"
+)
+
+// Material is a PEM encoded certificate chain and key of server. ClientCA
+// verifies certificates of clients, it is empty without mutual TLS.
+type Material struct {
+	Cert     []byte
+	Key      []byte
+	ClientCA []byte
+}
+
+func (m *Material) equal(o *Material) bool {
+	return o != nil && bytes.Equal(m.Cert, o.Cert) && bytes.Equal(m.Key, o.Key) && bytes.Equal(m.ClientCA, o.ClientCA)
+}
+
+// Source loads current certificates.
+type Source interface {
+	Load(ctx context.Context) (*Material, error)
+}
+
+// FileSource reads PEM files. ClientCA is optional.
+type FileSource struct {
+	Cert     string
+	Key      string
+	ClientCA string
+}
+
+func (s *FileSource) Load(ctx context.Context) (*Material, error) {
+	cert, err := os.ReadFile(s.Cert)
+	if err != nil {
+		return nil, err
+	}
+	key, err := os.ReadFile(s.Key)
+	if err != nil {
+		return nil, err
+	}
+
+	m := &Material{Cert: cert, Key: key}
+	if s.ClientCA != "" {
+		m.ClientCA, err = os.ReadFile(s.ClientCA)
+		if err != nil {
+			return nil, err
+		}
+	}
+	return m, nil
+}
+
+// Reloader serves last valid certificates of Source. Not valid certificates
+// are logged and previous ones are kept.
+type Reloader struct {
+	log        *logger.Logger
+	source     Source
+	clientAuth string
+
+	material atomic.Pointer[Material]
+	config   atomic.Pointer[tls.Config]
+}
+
+// NewReloader makes reloader of source. Client certificates are verified by
+// ClientCA in optional and require client auth modes.
+func NewReloader(log *logger.Logger, source Source, clientAuth string) (*Reloader, error) {
+	switch clientAuth {
+	case "":
+		clientAuth = CLIENT_AuthNone
+	case CLIENT_AuthNone, CLIENT_AuthOptional, CLIENT_AuthRequire:
+	default:
+		return nil, fmt.Errorf(ERROR_NotValidAuth, clientAuth)
+	}
+
+	return &Reloader{
+		log:        log,
+		source:     source,
+		clientAuth: clientAuth,
+	}, nil
+}
+
+// Reload loads certificates of source and applies them if they are changed.
+func (r *Reloader) Reload(ctx context.Context) error {
+	m, err := r.source.Load(ctx)
+	if err != nil {
+		return err
+	}
+	if m.equal(r.material.Load()) {
+		return nil
+	}
+
+	cfg, err := r.makeConfig(m)
+	if err != nil {
+		return err
+	}
+
+	r.config.Store(cfg)
+	r.material.Store(m)
+	if leaf := cfg.Certificates[0].Leaf; leaf != nil {
+		r.log.WithField("expires_at", leaf.NotAfter).Infof("TLS certificate %q loaded", leaf.Subject.CommonName)
+	}
+	return nil
+}
+
+// Run reloads certificates every interval until ctx is done.
+func (r *Reloader) Run(ctx context.Context, interval time.Duration) error {
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-ctx.Done():
+			return nil
+		case <-ticker.C:
+			err := r.Reload(ctx)
+			if err != nil {
+				r.log.Errorf("reload TLS certificates: %v", err)
+			}
+		}
+	}
+}
+
+// ServerConfig returns config of server which uses current certificates for
+// every new connection.
+func (r *Reloader) ServerConfig() *tls.Config {
+	return &tls.Config{
+		MinVersion: tls.VersionTLS12,
+		NextProtos: []string{"h2"},
+		GetConfigForClient: func(*tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg := r.config.Load()
+			if cfg == nil {
+				return nil, errors.New(ERROR_NotLoadedConfig)
+			}
+			return cfg, nil
+		},
+	}
+}
+
+func (r *Reloader) makeConfig(m *Material) (*tls.Config, error) {
+	if len(m.Cert) == 0 || len(m.Key) == 0 {
+		return nil, errors.New(ERROR_EmptyCertOrKey)
+	}
+
+	cert, err := tls.X509KeyPair(m.Cert, m.Key)
+	if err != nil {
+		return nil, err
+	}
+	cert.Leaf, err = x509.ParseCertificate(cert.Certificate[0])
+	if err != nil {
+		return nil, err
+	}
+
+	cfg := &tls.Config{
+		MinVersion:   tls.VersionTLS12,
+		NextProtos:   []string{"h2"},
+		Certificates: []tls.Certificate{cert},
+		ClientAuth:   tls.NoClientCert,
+	}
+	if r.clientAuth == CLIENT_AuthNone {
+		return cfg, nil
+	}
+
+	if len(m.ClientCA) == 0 {
+		return nil, fmt.Errorf(ERROR_NoClientCA, r.clientAuth)
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(m.ClientCA) {
+		return nil, errors.New(ERROR_NotValidCA)
+	}
+	cfg.ClientCAs = pool
+	cfg.ClientAuth = tls.RequireAndVerifyClientCert
+	if r.clientAuth == CLIENT_AuthOptional {
+		cfg.ClientAuth = tls.VerifyClientCertIfGiven
+	}
+	return cfg, nil
+}
@@ -0,0 +1,299 @@
+package tlscert
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"io"
+	"math/big"
+	"net"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/logger"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/health"
+	"google.golang.org/grpc/health/grpc_health_v1"
+)
+
+// testCert is a certificate with its key signed by parent or self-signed.
+type testCert struct {
+	cert    *x509.Certificate
+	key     *ecdsa.PrivateKey
+	certPEM []byte
+	keyPEM  []byte
+}
+
+var serial int64
+
+func newTestCert(tb testing.TB, name string, parent *testCert, isCA bool) *testCert {
+	tb.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	serial++
+	template := &x509.Certificate{
+		SerialNumber: big.NewInt(serial),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Minute),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth, x509.ExtKeyUsageClientAuth},
+		DNSNames:     []string{"localhost"},
+		IPAddresses:  []net.IP{net.ParseIP("127.0.0.1")},
+	}
+	if isCA {
+		template.IsCA = true
+		template.BasicConstraintsValid = true
+		template.KeyUsage |= x509.KeyUsageCertSign
+	}
+
+	signer, signerKey := template, key
+	if parent != nil {
+		signer, signerKey = parent.cert, parent.key
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, signer, &key.PublicKey, signerKey)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	keyDER, err := x509.MarshalECPrivateKey(key)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return &testCert{
+		cert:    cert,
+		key:     key,
+		certPEM: pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der}),
+		keyPEM:  pem.EncodeToMemory(&pem.Block{Type: "EC PRIVATE KEY", Bytes: keyDER}),
+	}
+}
+
+func (c *testCert) tls(tb testing.TB) tls.Certificate {
+	tb.Helper()
+
+	cert, err := tls.X509KeyPair(c.certPEM, c.keyPEM)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return cert
+}
+
+func writeFile(tb testing.TB, name string, data []byte) {
+	tb.Helper()
+
+	err := os.WriteFile(name, data, 0o600)
+	if err != nil {
+		tb.Fatal(err)
+	}
+}
+
+// serve starts gRPC server with health service and returns its address.
+func serve(tb testing.TB, reloader *Reloader) string {
+	tb.Helper()
+
+	lis, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		tb.Fatal(err)
+	}
+	server := grpc.NewServer(grpc.Creds(credentials.NewTLS(reloader.ServerConfig())))
+	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
+	go server.Serve(lis)
+	tb.Cleanup(server.Stop)
+
+	return lis.Addr().String()
+}
+
+// check calls health service with client certificate.
+func check(tb testing.TB, addr string, ca *testCert, client *testCert) error {
+	tb.Helper()
+
+	roots := x509.NewCertPool()
+	roots.AddCert(ca.cert)
+	cfg := &tls.Config{RootCAs: roots, ServerName: "localhost"}
+	if client != nil {
+		// certificate is sent even if server doesn't accept its CA
+		cert := client.tls(tb)
+		cfg.GetClientCertificate = func(*tls.CertificateRequestInfo) (*tls.Certificate, error) {
+			return &cert, nil
+		}
+	}
+
+	conn, err := grpc.Dial(addr, grpc.WithTransportCredentials(credentials.NewTLS(cfg)))
+	if err != nil {
+		tb.Fatal(err)
+	}
+	defer conn.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	_, err = grpc_health_v1.NewHealthClient(conn).Check(ctx, &grpc_health_v1.HealthCheckRequest{})
+	return err
+}
+
+func newTestReloader(tb testing.TB, source Source, clientAuth string) *Reloader {
+	tb.Helper()
+
+	log := logger.New()
+	log.Out = io.Discard
+	reloader, err := NewReloader(log, source, clientAuth)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	err = reloader.Reload(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return reloader
+}
+
+func TestMutualTLS(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	otherCA := newTestCert(t, "other ca", nil, true)
+	serverCert := newTestCert(t, "server", ca, false)
+	client := newTestCert(t, "client", ca, false)
+	otherClient := newTestCert(t, "other client", otherCA, false)
+
+	dir := t.TempDir()
+	source := &FileSource{
+		Cert:     filepath.Join(dir, "server.crt"),
+		Key:      filepath.Join(dir, "server.key"),
+		ClientCA: filepath.Join(dir, "ca.crt"),
+	}
+	writeFile(t, source.Cert, serverCert.certPEM)
+	writeFile(t, source.Key, serverCert.keyPEM)
+	writeFile(t, source.ClientCA, ca.certPEM)
+
+	tests := []struct {
+		name       string
+		clientAuth string
+		client     *testCert
+		serverCA   *testCert
+		accepted   bool
+	}{
+		{name: "require trusted client", clientAuth: CLIENT_AuthRequire, client: client, serverCA: ca, accepted: true},
+		{name: "require client of other CA", clientAuth: CLIENT_AuthRequire, client: otherClient, serverCA: ca},
+		{name: "require without client certificate", clientAuth: CLIENT_AuthRequire, serverCA: ca},
+		{name: "require untrusted server", clientAuth: CLIENT_AuthRequire, client: client, serverCA: otherCA},
+		{name: "optional without client certificate", clientAuth: CLIENT_AuthOptional, serverCA: ca, accepted: true},
+		{name: "optional client of other CA", clientAuth: CLIENT_AuthOptional, client: otherClient, serverCA: ca},
+		{name: "none without client certificate", clientAuth: CLIENT_AuthNone, serverCA: ca, accepted: true},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			addr := serve(t, newTestReloader(t, source, test.clientAuth))
+			err := check(t, addr, test.serverCA, test.client)
+			if (err == nil) != test.accepted {
+				t.Errorf("not valid result %v, expected accepted %t", err, test.accepted)
+			}
+		})
+	}
+}
+
+func TestReload(t *testing.T) {
+	ca := newTestCert(t, "ca", nil, true)
+	client := newTestCert(t, "client", ca, false)
+	first := newTestCert(t, "server", ca, false)
+	second := newTestCert(t, "server", ca, false)
+
+	dir := t.TempDir()
+	source := &FileSource{
+		Cert:     filepath.Join(dir, "server.crt"),
+		Key:      filepath.Join(dir, "server.key"),
+		ClientCA: filepath.Join(dir, "ca.crt"),
+	}
+	writeFile(t, source.Cert, first.certPEM)
+	writeFile(t, source.Key, first.keyPEM)
+	writeFile(t, source.ClientCA, ca.certPEM)
+
+	reloader := newTestReloader(t, source, CLIENT_AuthRequire)
+	addr := serve(t, reloader)
+
+	serverSerial := func() *big.Int {
+		roots := x509.NewCertPool()
+		roots.AddCert(ca.cert)
+		conn, err := tls.Dial("tcp", addr, &tls.Config{
+			RootCAs:      roots,
+			ServerName:   "localhost",
+			Certificates: []tls.Certificate{client.tls(t)},
+			NextProtos:   []string{"h2"},
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer conn.Close()
+		return conn.ConnectionState().PeerCertificates[0].SerialNumber
+	}
+
+	if s := serverSerial(); s.Cmp(first.cert.SerialNumber) != 0 {
+		t.Fatalf("not valid serial %v, expected %v", s, first.cert.SerialNumber)
+	}
+
+	writeFile(t, source.Cert, second.certPEM)
+	writeFile(t, source.Key, second.keyPEM)
+	err := reloader.Reload(context.Background())
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := serverSerial(); s.Cmp(second.cert.SerialNumber) != 0 {
+		t.Errorf("not valid serial after reload %v, expected %v", s, second.cert.SerialNumber)
+	}
+
+	// not valid certificate is rejected and previous one is kept
+	writeFile(t, source.Cert, []byte("not valid"))
+	err = reloader.Reload(context.Background())
+	if err == nil {
+		t.Error("expected error for not valid certificate")
+	}
+	if s := serverSerial(); s.Cmp(second.cert.SerialNumber) != 0 {
+		t.Errorf("not valid serial after failed reload %v, expected %v", s, second.cert.SerialNumber)
+	}
+	if err := check(t, addr, ca, client); err != nil {
+		t.Errorf("not valid result after failed reload %v", err)
+	}
+}
+
+func TestNewReloader(t *testing.T) {
+	log := logger.New()
+	log.Out = io.Discard
+
+	_, err := NewReloader(log, &FileSource{}, "verify")
+	if err == nil {
+		t.Error("expected error for not valid client auth")
+	}
+
+	ca := newTestCert(t, "ca", nil, true)
+	serverCert := newTestCert(t, "server", ca, false)
+	reloader, err := NewReloader(log, sourceFunc(func(context.Context) (*Material, error) {
+		return &Material{Cert: serverCert.certPEM, Key: serverCert.keyPEM}, nil
+	}), CLIENT_AuthRequire)
+	if err != nil {
+		t.Fatal(err)
+	}
+	err = reloader.Reload(context.Background())
+	if err == nil {
+		t.Error("expected error for mutual TLS without client CA")
+	}
+}
+
+type sourceFunc func(context.Context) (*Material, error)
+
+func (f sourceFunc) Load(ctx context.Context) (*Material, error) {
+	return f(ctx)
+}
@@ -1,6 +1,7 @@
 package grpc_transport
 
 import (
+	"crypto/tls"
 	"fmt"
 	"net"
 	"time"
@@ -9,6 +10,7 @@ import (
 	"github.com/Moranilt/jwt-http2/middleware"
 	service "github.com/Moranilt/jwt-http2/server"
 	"google.golang.org/grpc"
+	"google.golang.org/grpc/credentials"
 	"google.golang.org/grpc/health"
 	"google.golang.org/grpc/health/grpc_health_v1"
 	"google.golang.org/grpc/reflection"
@@ -18,9 +20,18 @@ type Transport struct {
 	*grpc.Server
 }
 
-func New(service *service.Server, mw *middleware.Middleware) *Transport {
+// New makes gRPC server. Connections are plaintext if tlsConfig is nil.
+func New(service *service.Server, mw *middleware.Middleware, tlsConfig *tls.Config) *Transport {
+	opts := []grpc.ServerOption{
+		grpc.ConnectionTimeout(10 * time.Second),
+		grpc.ChainUnaryInterceptor(mw.UnaryInterceptor, mw.AuthInterceptor, service.StatusInterceptor),
+	}
+	if tlsConfig != nil {
+		opts = append(opts, grpc.Creds(credentials.NewTLS(tlsConfig)))
+	}
+
 	server := &Transport{
-		Server: grpc.NewServer(grpc.ConnectionTimeout(10*time.Second), grpc.UnaryInterceptor(mw.UnaryInterceptor)),
+		Server: grpc.NewServer(opts...),
 	}
 	jwt_gRPC.RegisterAuthenticationServer(server, service)
 	grpc_health_v1.RegisterHealthServer(server, health.NewServer())
@@ -0,0 +1,186 @@
+package http_transport
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net"
+	"net/http"
+	"net/netip"
+
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/Moranilt/jwt-http2/middleware"
+	"github.com/Moranilt/jwt-http2/server"
+	"github.com/gorilla/mux"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/grpc/status"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+// GATEWAY_MaxBodySize is a max size of JSON request of gateway
+const GATEWAY_MaxBodySize = 1 << 20
+
+var (
+	gatewayUnmarshal = protojson.UnmarshalOptions{}
+	gatewayMarshal   = protojson.MarshalOptions{EmitUnpopulated: true}
+)
+
+// RegisterGateway adds JSON endpoints of every Authentication RPC. Request
+// and response bodies are protobuf messages in JSON mapping, errors are
+// google.rpc.Status with HTTP status of its code. Callers are authorized by
+// the same rules as gRPC callers.
+func RegisterGateway(router *mux.Router, log *logger.Logger, service *server.Server, mw *middleware.Middleware) {
+	v1 := router.PathPrefix("/v1").Subrouter()
+	handle := func(path string, rpc string, handler http.HandlerFunc) {
+		v1.HandleFunc(path, Authorize(log, mw, rpc, handler)).Methods(http.MethodPost)
+	}
+
+	handle("/tokens", "CreateTokens", MakeGatewayHandler(log, service.CreateTokens))
+	handle("/tokens/refresh", "RefreshTokens", MakeGatewayHandler(log, service.RefreshTokens))
+	handle("/tokens/user-id", "GetUserId", MakeGatewayHandler(log, service.GetUserId))
+	handle("/tokens/check", "CheckTokenExistence", MakeGatewayHandler(log, service.CheckTokenExistence))
+	handle("/tokens/revoke", "RevokeTokens", MakeGatewayHandler(log, service.RevokeTokens))
+	handle("/tokens/introspect", "IntrospectToken", MakeGatewayHandler(log, service.IntrospectToken))
+	handle("/tokens/revoke-token", "RevokeToken", MakeGatewayHandler(log, service.RevokeToken))
+	handle("/keys", "GetPublicKeys", MakeGatewayHandler(log, service.GetPublicKeys))
+	handle("/sessions/current", "GetSession", MakeGatewayHandler(log, service.GetSession))
+	handle("/sessions/list", "ListUserSessions", MakeGatewayHandler(log, service.ListUserSessions))
+	handle("/sessions/revoke-all", "RevokeAllUserSessions", MakeGatewayHandler(log, service.RevokeAllUserSessions))
+}
+
+// Authorize allows callers of RPC identified by API key header. REST port is
+// served without TLS, so callers with client certificates use gRPC.
+func Authorize(log *logger.Logger, mw *middleware.Middleware, rpc string, next http.HandlerFunc) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		caller, err := mw.Authorize(middleware.FullMethod(rpc), r.Header.Get(middleware.HEADER_APIKey), nil)
+		if err != nil {
+			st := server.Status(err)
+			writeStatus(log, w, HTTPStatusFromCode(st.Code()), st)
+			return
+		}
+		if caller != "" {
+			r = r.WithContext(context.WithValue(r.Context(), logger.CtxCaller, caller))
+		}
+
+		next(w, r)
+	})
+}
+
+// MakeGatewayHandler decodes JSON body into request of RPC and writes its
+// response as JSON. Empty body is an empty request.
+func MakeGatewayHandler[Req any, PReq interface {
+	*Req
+	proto.Message
+}, Res proto.Message](log *logger.Logger, rpc func(context.Context, PReq) (Res, error)) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, err := io.ReadAll(http.MaxBytesReader(w, r.Body, GATEWAY_MaxBodySize))
+		var maxBytesErr *http.MaxBytesError
+		if errors.As(err, &maxBytesErr) {
+			writeStatus(log, w, http.StatusRequestEntityTooLarge, status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+		if err != nil {
+			writeStatus(log, w, http.StatusBadRequest, status.New(codes.InvalidArgument, err.Error()))
+			return
+		}
+
+		req := PReq(new(Req))
+		if len(body) > 0 {
+			err = gatewayUnmarshal.Unmarshal(body, req)
+			if err != nil {
+				writeStatus(log, w, http.StatusBadRequest, status.New(codes.InvalidArgument, err.Error()))
+				return
+			}
+		}
+
+		res, err := rpc(rpcContext(r), req)
+		if err != nil {
+			st := server.Status(err)
+			writeStatus(log, w, HTTPStatusFromCode(st.Code()), st)
+			return
+		}
+
+		b, err := gatewayMarshal.Marshal(res)
+		if err != nil {
+			log.Error(err)
+			writeStatus(log, w, http.StatusInternalServerError, status.New(codes.Internal, err.Error()))
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		w.Header().Set("Cache-Control", "no-store")
+		w.WriteHeader(http.StatusOK)
+		if _, err := w.Write(b); err != nil {
+			log.Error(err)
+		}
+	})
+}
+
+// HTTPStatusFromCode maps gRPC code to HTTP status as
+// google.golang.org/genproto/googleapis/rpc/code describes it.
+func HTTPStatusFromCode(code codes.Code) int {
+	switch code {
+	case codes.OK:
+		return http.StatusOK
+	case codes.Canceled:
+		// client closed request
+		return 499
+	case codes.InvalidArgument, codes.FailedPrecondition, codes.OutOfRange:
+		return http.StatusBadRequest
+	case codes.DeadlineExceeded:
+		return http.StatusGatewayTimeout
+	case codes.NotFound:
+		return http.StatusNotFound
+	case codes.AlreadyExists, codes.Aborted:
+		return http.StatusConflict
+	case codes.PermissionDenied:
+		return http.StatusForbidden
+	case codes.Unauthenticated:
+		return http.StatusUnauthorized
+	case codes.ResourceExhausted:
+		return http.StatusTooManyRequests
+	case codes.Unimplemented:
+		return http.StatusNotImplemented
+	case codes.Unavailable:
+		return http.StatusServiceUnavailable
+	default:
+		return http.StatusInternalServerError
+	}
+}
+
+// rpcContext passes address and user agent of HTTP client to RPC as gRPC
+// peer and metadata, so sessions made by gateway have them too.
+func rpcContext(r *http.Request) context.Context {
+	md := metadata.MD{}
+	if ua := r.UserAgent(); ua != "" {
+		md.Set(server.MD_UserAgent, ua)
+	}
+	// hops are trusted only if client address is a trusted proxy
+	if forwarded := r.Header.Values(server.MD_ForwardedFor); len(forwarded) > 0 {
+		md.Set(server.MD_ForwardedFor, forwarded...)
+	}
+
+	ctx := metadata.NewIncomingContext(r.Context(), md)
+	if addr, err := netip.ParseAddrPort(r.RemoteAddr); err == nil {
+		ctx = peer.NewContext(ctx, &peer.Peer{Addr: net.TCPAddrFromAddrPort(addr)})
+	}
+	return ctx
+}
+
+func writeStatus(log *logger.Logger, w http.ResponseWriter, httpStatus int, st *status.Status) {
+	b, err := gatewayMarshal.Marshal(st.Proto())
+	if err != nil {
+		log.Error(err)
+		http.Error(w, http.StatusText(httpStatus), httpStatus)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(httpStatus)
+	if _, err := w.Write(b); err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,411 @@
+package http_transport
+
+import (
+	"context"
+	"encoding/base64"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/jwt_gRPC"
+	"github.com/Moranilt/jwt-http2/keyring"
+	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/Moranilt/jwt-http2/middleware"
+	"github.com/Moranilt/jwt-http2/server"
+	"github.com/Moranilt/jwt-http2/storage"
+	"google.golang.org/genproto/googleapis/rpc/errdetails"
+	"google.golang.org/genproto/googleapis/rpc/status"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/peer"
+	"google.golang.org/protobuf/encoding/protojson"
+	"google.golang.org/protobuf/proto"
+)
+
+const testConfig = "issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 15m\n  refresh: 1d\n"
+
+func newTestHandler(tb testing.TB) http.Handler {
+	tb.Helper()
+	return newTestHandlerWithConfig(tb, testConfig)
+}
+
+func newTestHandlerWithConfig(tb testing.TB, yaml string) http.Handler {
+	tb.Helper()
+
+	log := logger.New()
+	log.Out = io.Discard
+	cfg := config.New(log)
+	err := cfg.WatchConsul(context.Background(), testConsulKey, []config.WatchConsulBody{
+		{Key: testConsulKey, Value: base64.StdEncoding.EncodeToString([]byte(yaml))},
+	})
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	keys := keyring.NewTenants(log, cfg, func(string) keyring.Store {
+		return keyring.NewMemoryStore()
+	})
+	err = keys.Init(context.Background())
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	service, err := server.New(log, cfg, storage.NewMemory(), storage.NewKeySchema(storage.DEFAULT_KeyPrefix), keys)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return New(":0", log, cfg, nil, service, keys, middleware.New(log, cfg)).Handler
+}
+
+// call posts JSON body and decodes response into res or status of error.
+func call(tb testing.TB, handler http.Handler, path string, body string, res proto.Message) (*httptest.ResponseRecorder, *status.Status) {
+	tb.Helper()
+
+	w := httptest.NewRecorder()
+	handler.ServeHTTP(w, httptest.NewRequest(http.MethodPost, path, strings.NewReader(body)))
+	if w.Header().Get("Content-Type") != "application/json" {
+		tb.Fatalf("not valid content type %q, expected %q", w.Header().Get("Content-Type"), "application/json")
+	}
+
+	if w.Code != http.StatusOK {
+		st := new(status.Status)
+		err := protojson.Unmarshal(w.Body.Bytes(), st)
+		if err != nil {
+			tb.Fatal(err)
+		}
+		return w, st
+	}
+
+	err := protojson.Unmarshal(w.Body.Bytes(), res)
+	if err != nil {
+		tb.Fatal(err)
+	}
+	return w, nil
+}
+
+func TestGateway(t *testing.T) {
+	handler := newTestHandler(t)
+
+	tokens := new(jwt_gRPC.CreateTokensResponse)
+	_, st := call(t, handler, "/v1/tokens", `{"UserId": "123", "UserClaims": {"role": "admin"}}`, tokens)
+	if st != nil {
+		t.Fatalf("create tokens: %v", st)
+	}
+	if tokens.AccessToken == "" || tokens.RefreshToken == "" {
+		t.Fatalf("not valid tokens %v", tokens)
+	}
+
+	userId := new(jwt_gRPC.GetUserIdResponse)
+	_, st = call(t, handler, "/v1/tokens/user-id", `{"AccessToken": "`+tokens.AccessToken+`"}`, userId)
+	if st != nil {
+		t.Fatalf("get user id: %v", st)
+	}
+	if userId.UserId != "123" {
+		t.Errorf("not valid user id %q, expected %q", userId.UserId, "123")
+	}
+
+	exists := new(jwt_gRPC.CheckTokenExistenceResponse)
+	_, st = call(t, handler, "/v1/tokens/check", `{"AccessToken": "`+tokens.AccessToken+`"}`, exists)
+	if st != nil {
+		t.Fatalf("check token existence: %v", st)
+	}
+	if !exists.GetAccessToken() {
+		t.Errorf("not valid existence %v, expected true", exists.GetAccessToken())
+	}
+
+	session := new(jwt_gRPC.Session)
+	_, st = call(t, handler, "/v1/sessions/current", `{"AccessToken": "`+tokens.AccessToken+`"}`, session)
+	if st != nil {
+		t.Fatalf("get session: %v", st)
+	}
+	if session.UserId != "123" || session.Id == "" {
+		t.Errorf("not valid session %v", session)
+	}
+
+	sessions := new(jwt_gRPC.ListUserSessionsResponse)
+	_, st = call(t, handler, "/v1/sessions/list", `{"UserId": "123"}`, sessions)
+	if st != nil {
+		t.Fatalf("list user sessions: %v", st)
+	}
+	if len(sessions.Sessions) != 1 || sessions.Sessions[0].Id != session.Id {
+		t.Errorf("not valid sessions %v, expected %v", sessions.Sessions, session)
+	}
+
+	introspection := new(jwt_gRPC.IntrospectTokenResponse)
+	_, st = call(t, handler, "/v1/tokens/introspect", `{"Token": "`+tokens.AccessToken+`"}`, introspection)
+	if st != nil {
+		t.Fatalf("introspect token: %v", st)
+	}
+	if !introspection.Active || introspection.UserId != "123" {
+		t.Errorf("not valid introspection %v", introspection)
+	}
+
+	keys := new(jwt_gRPC.GetPublicKeysResponse)
+	_, st = call(t, handler, "/v1/keys", "", keys)
+	if st != nil {
+		t.Fatalf("get public keys: %v", st)
+	}
+	if len(keys.Keys) == 0 || keys.Keys[0].Kid == "" {
+		t.Errorf("not valid keys %v", keys.Keys)
+	}
+
+	refreshed := new(jwt_gRPC.RefreshTokenResponse)
+	_, st = call(t, handler, "/v1/tokens/refresh", `{"RefreshToken": "`+tokens.RefreshToken+`"}`, refreshed)
+	if st != nil {
+		t.Fatalf("refresh tokens: %v", st)
+	}
+
+	revoked := new(jwt_gRPC.RevokeTokensResponse)
+	_, st = call(t, handler, "/v1/tokens/revoke", `{"RefreshToken": "`+refreshed.RefreshToken+`"}`, revoked)
+	if st != nil {
+		t.Fatalf("revoke tokens: %v", st)
+	}
+	if !revoked.Revoked {
+		t.Errorf("not valid revoked %v, expected true", revoked.Revoked)
+	}
+
+	other := new(jwt_gRPC.CreateTokensResponse)
+	_, st = call(t, handler, "/v1/tokens", `{"UserId": "123"}`, other)
+	if st != nil {
+		t.Fatalf("create tokens: %v", st)
+	}
+	_, st = call(t, handler, "/v1/tokens/revoke-token", `{"Token": "`+other.AccessToken+`"}`, new(jwt_gRPC.RevokeTokenResponse))
+	if st != nil {
+		t.Fatalf("revoke token: %v", st)
+	}
+	_, st = call(t, handler, "/v1/tokens/introspect", `{"Token": "`+other.AccessToken+`"}`, introspection)
+	if st != nil {
+		t.Fatalf("introspect token: %v", st)
+	}
+	if introspection.Active {
+		t.Error("not valid active of revoked token, expected false")
+	}
+
+	_, st = call(t, handler, "/v1/tokens", `{"UserId": "123"}`, other)
+	if st != nil {
+		t.Fatalf("create tokens: %v", st)
+	}
+	revokedAll := new(jwt_gRPC.RevokeAllUserSessionsResponse)
+	_, st = call(t, handler, "/v1/sessions/revoke-all", `{"UserId": "123"}`, revokedAll)
+	if st != nil {
+		t.Fatalf("revoke all user sessions: %v", st)
+	}
+	if revokedAll.Revoked != 1 {
+		t.Errorf("not valid revoked sessions %d, expected 1", revokedAll.Revoked)
+	}
+}
+
+func TestGatewayErrors(t *testing.T) {
+	handler := newTestHandler(t)
+
+	tests := []struct {
+		name   string
+		path   string
+		body   string
+		status int
+		code   codes.Code
+		reason string
+	}{
+		{name: "not valid json", path: "/v1/tokens", body: `{"UserId":`, status: http.StatusBadRequest, code: codes.InvalidArgument},
+		{name: "unknown field", path: "/v1/tokens", body: `{"User": "123"}`, status: http.StatusBadRequest, code: codes.InvalidArgument},
+		{name: "too large body", path: "/v1/tokens", body: `{"UserId": "` + strings.Repeat("a", GATEWAY_MaxBodySize) + `"}`, status: http.StatusRequestEntityTooLarge, code: codes.InvalidArgument},
+		{name: "unknown tenant", path: "/v1/tokens", body: `{"UserId": "123", "TenantId": "shop"}`, status: http.StatusNotFound, code: codes.NotFound, reason: server.REASON_UnknownTenant},
+		{name: "empty request", path: "/v1/tokens/check", body: "", status: http.StatusBadRequest, code: codes.InvalidArgument, reason: server.REASON_InvalidArgument},
+		{name: "malformed token", path: "/v1/tokens/user-id", body: `{"AccessToken": "token"}`, status: http.StatusUnauthorized, code: codes.Unauthenticated, reason: server.REASON_TokenMalformed},
+		{name: "unknown refresh token", path: "/v1/tokens/refresh", body: `{"RefreshToken": "token"}`, status: http.StatusUnauthorized, code: codes.Unauthenticated, reason: server.REASON_TokenMalformed},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w, st := call(t, handler, test.path, test.body, nil)
+			if w.Code != test.status {
+				t.Fatalf("not valid status %d, expected %d: %s", w.Code, test.status, w.Body)
+			}
+			if codes.Code(st.Code) != test.code {
+				t.Errorf("not valid code %v, expected %v", codes.Code(st.Code), test.code)
+			}
+			if st.Message == "" {
+				t.Error("empty message of status")
+			}
+			if test.reason != "" {
+				info := new(errdetails.ErrorInfo)
+				if len(st.Details) != 1 || st.Details[0].UnmarshalTo(info) != nil {
+					t.Fatalf("not valid details %v, expected ErrorInfo", st.Details)
+				}
+				if info.Reason != test.reason || info.Domain != server.ERROR_Domain {
+					t.Errorf("not valid reason %q of %q, expected %q of %q", info.Reason, info.Domain, test.reason, server.ERROR_Domain)
+				}
+			}
+		})
+	}
+}
+
+func TestGatewaySessionMetadata(t *testing.T) {
+	log := logger.New()
+	log.Out = io.Discard
+
+	var userAgent, addr string
+	handler := MakeGatewayHandler(log, func(ctx context.Context, req *jwt_gRPC.CreateTokensRequest) (*jwt_gRPC.CreateTokensResponse, error) {
+		md, _ := metadata.FromIncomingContext(ctx)
+		if ua := md.Get(server.MD_UserAgent); len(ua) > 0 {
+			userAgent = ua[0]
+		}
+		if p, ok := peer.FromContext(ctx); ok {
+			addr = p.Addr.String()
+		}
+		return &jwt_gRPC.CreateTokensResponse{}, nil
+	})
+
+	r := httptest.NewRequest(http.MethodPost, "/v1/tokens", strings.NewReader(`{"UserId": "123"}`))
+	r.RemoteAddr = "192.0.2.10:51234"
+	r.Header.Set("User-Agent", "browser/1.0")
+	handler.ServeHTTP(httptest.NewRecorder(), r)
+
+	if userAgent != "browser/1.0" {
+		t.Errorf("not valid user agent %q, expected %q", userAgent, "browser/1.0")
+	}
+	if addr != r.RemoteAddr {
+		t.Errorf("not valid peer address %q, expected %q", addr, r.RemoteAddr)
+	}
+}
+
+func TestGatewayRequestId(t *testing.T) {
+	handler := newTestHandler(t)
+
+	tests := []struct {
+		name     string
+		id       string
+		expected string
+	}{
+		{name: "id of caller", id: "caller-id", expected: "caller-id"},
+		{name: "not valid id", id: "caller id"},
+		{name: "without id"},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(http.MethodPost, "/v1/tokens", strings.NewReader(`{"UserId": "123"}`))
+			if test.id != "" {
+				r.Header.Set(middleware.HEADER_RequestId, test.id)
+			}
+			handler.ServeHTTP(w, r)
+
+			id := w.Header().Get(middleware.HEADER_RequestId)
+			if id == "" || (test.expected != "" && id != test.expected) || (test.expected == "" && id == test.id) {
+				t.Errorf("not valid request id %q, expected %q", id, test.expected)
+			}
+		})
+	}
+}
+
+func TestGatewayAuthorization(t *testing.T) {
+	handler := newTestHandlerWithConfig(t, testConfig+"auth:\n"+
+		"  callers:\n"+
+		"    login:\n      api_keys: ["+config.HashAPIKey("login-key")+"]\n"+
+		"    orders:\n      api_keys: ["+config.HashAPIKey("orders-key")+"]\n"+
+		"    admin:\n      api_keys: ["+config.HashAPIKey("admin-key")+"]\n"+
+		"  rules:\n    CreateTokens: [login]\n    IntrospectToken: [orders]\n    RotateKeys: [admin]\n    Config: [admin]\n    \"*\": [\"*\"]\n")
+
+	tests := []struct {
+		name   string
+		method string
+		path   string
+		body   string
+		apiKey string
+		status int
+	}{
+		{name: "allowed caller", path: "/v1/tokens", body: `{"UserId": "123"}`, apiKey: "login-key", status: http.StatusOK},
+		{name: "not allowed caller", path: "/v1/tokens", body: `{"UserId": "123"}`, apiKey: "orders-key", status: http.StatusForbidden},
+		{name: "unknown api key", path: "/v1/tokens", body: `{"UserId": "123"}`, apiKey: "other-key", status: http.StatusUnauthorized},
+		{name: "without api key", path: "/v1/tokens", body: `{"UserId": "123"}`, status: http.StatusUnauthorized},
+		{name: "introspection in gateway by other caller", path: "/v1/tokens/introspect", body: `{"Token": "token"}`, apiKey: "login-key", status: http.StatusForbidden},
+		{name: "sessions without api key", path: "/v1/sessions/list", body: `{"UserId": "123"}`, status: http.StatusUnauthorized},
+		// request is not valid, but caller is allowed
+		{name: "any caller", path: "/v1/tokens/check", body: "", apiKey: "orders-key", status: http.StatusBadRequest},
+		{name: "rotation by admin", path: "/keys/rotate", apiKey: "admin-key", status: http.StatusOK},
+		{name: "rotation by other caller", path: "/keys/rotate", apiKey: "orders-key", status: http.StatusForbidden},
+		{name: "rotation without api key", path: "/keys/rotate", status: http.StatusUnauthorized},
+		{name: "introspection by allowed caller", path: "/introspect", body: "token=token", apiKey: "orders-key", status: http.StatusOK},
+		{name: "introspection by other caller", path: "/introspect", body: "token=token", apiKey: "login-key", status: http.StatusForbidden},
+		{name: "introspection without api key", path: "/introspect", body: "token=token", status: http.StatusUnauthorized},
+		{name: "revocation by any caller", path: "/revoke", body: "token=token", apiKey: "login-key", status: http.StatusOK},
+		{name: "revocation without api key", path: "/revoke", body: "token=token", status: http.StatusUnauthorized},
+		{name: "config validation by admin", path: "/config/validate", body: testConfig, apiKey: "admin-key", status: http.StatusOK},
+		{name: "config validation by other caller", path: "/config/validate", body: testConfig, apiKey: "orders-key", status: http.StatusForbidden},
+		{name: "config validation without api key", path: "/config/validate", body: testConfig, status: http.StatusUnauthorized},
+		{name: "config by admin", method: http.MethodGet, path: "/config", apiKey: "admin-key", status: http.StatusOK},
+		{name: "config by other caller", method: http.MethodGet, path: "/config", apiKey: "login-key", status: http.StatusForbidden},
+		{name: "config without api key", method: http.MethodGet, path: "/config", status: http.StatusUnauthorized},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			method := test.method
+			if method == "" {
+				method = http.MethodPost
+			}
+			w := httptest.NewRecorder()
+			r := httptest.NewRequest(method, test.path, strings.NewReader(test.body))
+			if strings.HasPrefix(test.body, "token=") {
+				r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+			}
+			if test.apiKey != "" {
+				r.Header.Set(middleware.HEADER_APIKey, test.apiKey)
+			}
+			handler.ServeHTTP(w, r)
+			if w.Code != test.status {
+				t.Errorf("not valid status %d, expected %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+}
+
+func TestAdminEndpointsWithoutAuth(t *testing.T) {
+	handler := newTestHandler(t)
+
+	tests := []struct {
+		method string
+		path   string
+	}{
+		{method: http.MethodPost, path: "/keys/rotate"},
+		{method: http.MethodGet, path: "/config"},
+		{method: http.MethodPost, path: "/config/validate"},
+	}
+	for _, test := range tests {
+		w := httptest.NewRecorder()
+		handler.ServeHTTP(w, httptest.NewRequest(test.method, test.path, strings.NewReader(testConfig)))
+		if w.Code != http.StatusUnauthorized {
+			t.Errorf("not valid status of %s %s %d, expected %d: %s", test.method, test.path, w.Code, http.StatusUnauthorized, w.Body)
+		}
+	}
+}
+
+func TestHTTPStatusFromCode(t *testing.T) {
+	tests := []struct {
+		code   codes.Code
+		status int
+	}{
+		{code: codes.OK, status: http.StatusOK},
+		{code: codes.InvalidArgument, status: http.StatusBadRequest},
+		{code: codes.Unauthenticated, status: http.StatusUnauthorized},
+		{code: codes.PermissionDenied, status: http.StatusForbidden},
+		{code: codes.NotFound, status: http.StatusNotFound},
+		{code: codes.AlreadyExists, status: http.StatusConflict},
+		{code: codes.ResourceExhausted, status: http.StatusTooManyRequests},
+		{code: codes.Unavailable, status: http.StatusServiceUnavailable},
+		{code: codes.DeadlineExceeded, status: http.StatusGatewayTimeout},
+		{code: codes.Unknown, status: http.StatusInternalServerError},
+		{code: codes.Internal, status: http.StatusInternalServerError},
+	}
+
+	for _, test := range tests {
+		if status := HTTPStatusFromCode(test.code); status != test.status {
+			t.Errorf("not valid status of %v %d, expected %d", test.code, status, test.status)
+		}
+	}
+}
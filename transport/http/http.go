@@ -1,19 +1,106 @@
 package http_transport
 
 import (
+	"crypto/sha256"
+	"crypto/subtle"
 	"encoding/json"
+	"errors"
 	"io"
 	"net/http"
+	"strings"
 	"time"
 
 	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/keyring"
 	"github.com/Moranilt/jwt-http2/logger"
+	"github.com/Moranilt/jwt-http2/middleware"
+	"github.com/Moranilt/jwt-http2/server"
 	"github.com/gorilla/mux"
 )
 
-func New(addr string, log *logger.Logger, cfg *config.Config, consulKey string) *http.Server {
+const (
+	JWKS_CacheControl = "public, max-age=300"
+
+	// PARAM_Tenant is a query or form parameter with tenant id
+	PARAM_Tenant = "tenant"
+
+	// WATCH_MaxBodySize is a max size of Consul watch request
+	WATCH_MaxBodySize = 1 << 20
+	// CONFIG_MaxBodySize is a max size of validated config
+	CONFIG_MaxBodySize = 1 << 20
+
+	ERROR_InvalidToken   = "invalid_token"
+	ERROR_InvalidRequest = "invalid_request"
+	ERROR_InvalidConfig  = "invalid_config"
+)
+
+type RotateKeysResponse struct {
+	Kid string `json:"kid"`
+}
+
+// IntrospectionResponse is a token introspection response described in RFC 7662.
+type IntrospectionResponse struct {
+	Active     bool              `json:"active"`
+	TokenType  string            `json:"token_type,omitempty"`
+	UserId     string            `json:"user_id,omitempty"`
+	Sub        string            `json:"sub,omitempty"`
+	Exp        int64             `json:"exp,omitempty"`
+	Iat        int64             `json:"iat,omitempty"`
+	Nbf        int64             `json:"nbf,omitempty"`
+	Aud        []string          `json:"aud,omitempty"`
+	Iss        string            `json:"iss,omitempty"`
+	Jti        string            `json:"jti,omitempty"`
+	UserClaims map[string]string `json:"user_claims,omitempty"`
+	Tenant     string            `json:"tenant,omitempty"`
+}
+
+// ConfigResponse is an application config with durations as strings.
+type ConfigResponse struct {
+	Issuer    string          `json:"issuer"`
+	Subject   string          `json:"subject"`
+	Audience  []string        `json:"audience"`
+	Algorithm string          `json:"algorithm"`
+	TTL       ConfigTTL       `json:"ttl"`
+	Keys      *ConfigKeys     `json:"keys,omitempty"`
+	Version   *config.Version `json:"version,omitempty"`
+
+	Tenants map[string]*ConfigResponse `json:"tenants,omitempty"`
+}
+
+type ConfigTTL struct {
+	Access  string `json:"access"`
+	Refresh string `json:"refresh"`
+}
+
+type ConfigKeys struct {
+	Rotation string `json:"rotation"`
+	Retired  int    `json:"retired"`
+}
+
+type ValidateConfigResponse struct {
+	Valid  bool            `json:"valid"`
+	Errors []string        `json:"errors,omitempty"`
+	Config *ConfigResponse `json:"config,omitempty"`
+}
+
+type ErrorResponse struct {
+	Error            string `json:"error"`
+	ErrorDescription string `json:"error_description,omitempty"`
+}
+
+func New(addr string, log *logger.Logger, cfg *config.Config, consulEnv *config.ConsulEnv, service *server.Server, keys *keyring.Tenants, mw *middleware.Middleware) *http.Server {
 	router := mux.NewRouter()
-	router.HandleFunc("/watch", MakeWatchHandler(log, cfg, consulKey)).Methods(http.MethodPost)
+	router.Use(mw.HTTP)
+	if consulEnv != nil && consulEnv.WatchMode == config.WATCH_ModeWebhook {
+		router.HandleFunc("/watch", RequireToken(log, consulEnv.WatchToken, MakeWatchHandler(log, cfg, consulEnv.Key()))).Methods(http.MethodPost)
+	}
+	router.HandleFunc("/config", Authorize(log, mw, config.AUTH_Config, MakeConfigHandler(log, cfg))).Methods(http.MethodGet)
+	router.HandleFunc("/config/validate", Authorize(log, mw, config.AUTH_Config, MakeValidateConfigHandler(log, cfg))).Methods(http.MethodPost)
+	router.HandleFunc("/.well-known/jwks.json", MakeJWKSHandler(log, service)).Methods(http.MethodGet)
+	router.HandleFunc("/introspect", Authorize(log, mw, "IntrospectToken", MakeIntrospectHandler(log, service))).Methods(http.MethodPost)
+	router.HandleFunc("/revoke", Authorize(log, mw, "RevokeToken", MakeRevokeHandler(log, service))).Methods(http.MethodPost)
+	router.HandleFunc("/keys/rotate", Authorize(log, mw, config.AUTH_RotateKeys, MakeRotateKeysHandler(log, keys))).Methods(http.MethodPost)
+	RegisterGateway(router, log, service, mw)
 
 	server := &http.Server{
 		Addr:         addr,
@@ -24,26 +111,252 @@ func New(addr string, log *logger.Logger, cfg *config.Config, consulKey string)
 	return server
 }
 
+// RequireToken allows requests with bearer token only.
+func RequireToken(log *logger.Logger, token string, next http.HandlerFunc) http.HandlerFunc {
+	expected := sha256.Sum256([]byte(token))
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		provided, ok := strings.CutPrefix(r.Header.Get("Authorization"), "Bearer ")
+		actual := sha256.Sum256([]byte(provided))
+		if !ok || token == "" || subtle.ConstantTimeCompare(actual[:], expected[:]) != 1 {
+			log.WithRequestInfo(r.Context()).Warnf("%s %s: not valid bearer token", r.Method, r.URL.Path)
+			w.Header().Set("WWW-Authenticate", `Bearer error="invalid_token"`)
+			writeJSON(log, w, http.StatusUnauthorized, ErrorResponse{Error: ERROR_InvalidToken})
+			return
+		}
+
+		next(w, r)
+	})
+}
+
 func MakeWatchHandler(log *logger.Logger, cfg *config.Config, consulKey string) http.HandlerFunc {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		b, err := io.ReadAll(r.Body)
+		var cb []config.WatchConsulBody
+		err := json.NewDecoder(http.MaxBytesReader(w, r.Body, WATCH_MaxBodySize)).Decode(&cb)
 		if err != nil {
 			log.Error(err)
+			status := http.StatusBadRequest
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(log, w, status, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: err.Error(),
+			})
 			return
 		}
 
-		var cb []config.WatchConsulBody
-		err = json.Unmarshal(b, &cb)
+		err = cfg.WatchConsul(r.Context(), consulKey, cb)
 		if err != nil {
 			log.Error(err)
+			writeJSON(log, w, http.StatusUnprocessableEntity, ErrorResponse{
+				Error:            ERROR_InvalidConfig,
+				ErrorDescription: err.Error(),
+			})
 			return
 		}
+	})
+}
 
-		err = cfg.WatchConsul(r.Context(), consulKey, cb)
+// MakeConfigHandler shows active config and its version.
+func MakeConfigHandler(log *logger.Logger, cfg *config.Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		version := cfg.Version()
+		response := makeConfigResponse(cfg.Current())
+		response.Version = &version
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(log, w, http.StatusOK, response)
+	})
+}
+
+// MakeValidateConfigHandler validates YAML config from body without applying
+// it. Not valid config is returned with 422 and list of errors.
+func MakeValidateConfigHandler(log *logger.Logger, cfg *config.Config) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		value, err := io.ReadAll(http.MaxBytesReader(w, r.Body, CONFIG_MaxBodySize))
+		if err != nil {
+			log.Error(err)
+			status := http.StatusBadRequest
+			var maxBytesErr *http.MaxBytesError
+			if errors.As(err, &maxBytesErr) {
+				status = http.StatusRequestEntityTooLarge
+			}
+			writeJSON(log, w, status, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: err.Error(),
+			})
+			return
+		}
+
+		app, err := cfg.Validate(value)
+		if err != nil {
+			var validationErr config.ValidationError
+			if !errors.As(err, &validationErr) {
+				validationErr = config.ValidationError{err.Error()}
+			}
+			writeJSON(log, w, http.StatusUnprocessableEntity, ValidateConfigResponse{Errors: validationErr})
+			return
+		}
+
+		writeJSON(log, w, http.StatusOK, ValidateConfigResponse{
+			Valid:  true,
+			Config: makeConfigResponse(app),
+		})
+	})
+}
+
+func makeConfigResponse(app *config.AppConfig[time.Duration]) *ConfigResponse {
+	response := &ConfigResponse{
+		Issuer:    app.Issuer,
+		Subject:   app.Subject,
+		Audience:  app.Audience,
+		Algorithm: app.Algorithm,
+		TTL: ConfigTTL{
+			Access:  app.TTL.Access.String(),
+			Refresh: app.TTL.Refresh.String(),
+		},
+	}
+	if app.Keys != nil {
+		response.Keys = &ConfigKeys{
+			Rotation: app.Keys.Rotation.String(),
+			Retired:  app.Keys.Retired,
+		}
+	}
+	if len(app.Tenants) > 0 {
+		response.Tenants = make(map[string]*ConfigResponse, len(app.Tenants))
+		for id, tenant := range app.Tenants {
+			response.Tenants[id] = makeConfigResponse(tenant)
+		}
+	}
+	return response
+}
+
+func MakeJWKSHandler(log *logger.Logger, service *server.Server) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		set, err := service.PublicKeys(r.Context(), r.FormValue(PARAM_Tenant))
+		if errors.Is(err, server.ErrUnknownTenant) {
+			writeJSON(log, w, http.StatusNotFound, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", JWKS_CacheControl)
+		writeJSON(log, w, http.StatusOK, set)
+	})
+}
+
+func MakeRotateKeysHandler(log *logger.Logger, keys *keyring.Tenants) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rotator, err := keys.Rotator(r.FormValue(PARAM_Tenant))
+		if err != nil {
+			writeJSON(log, w, http.StatusBadRequest, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: err.Error(),
+			})
+			return
+		}
+
+		key, err := rotator.Rotate(r.Context())
 		if err != nil {
 			log.Error(err)
 			http.Error(w, err.Error(), http.StatusBadGateway)
 			return
 		}
+
+		writeJSON(log, w, http.StatusOK, RotateKeysResponse{Kid: key.ID})
+	})
+}
+
+// MakeIntrospectHandler handles form with token and token_type_hint as
+// described in RFC 7662.
+func MakeIntrospectHandler(log *logger.Logger, service *server.Server) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.PostFormValue("token")
+		if token == "" {
+			writeJSON(log, w, http.StatusBadRequest, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: server.ERROR_ProvideToken,
+			})
+			return
+		}
+
+		result, err := service.Introspect(r.Context(), r.PostFormValue(PARAM_Tenant), token, r.PostFormValue("token_type_hint"))
+		if errors.Is(err, server.ErrUnknownTenant) {
+			writeJSON(log, w, http.StatusBadRequest, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			http.Error(w, http.StatusText(http.StatusInternalServerError), http.StatusInternalServerError)
+			return
+		}
+
+		w.Header().Set("Cache-Control", "no-store")
+		writeJSON(log, w, http.StatusOK, IntrospectionResponse{
+			Active:     result.Active,
+			TokenType:  result.TokenType,
+			UserId:     result.UserId,
+			Sub:        result.Sub,
+			Exp:        result.Exp,
+			Iat:        result.Iat,
+			Nbf:        result.Nbf,
+			Aud:        result.Aud,
+			Iss:        result.Iss,
+			Jti:        result.Jti,
+			UserClaims: result.UserClaims,
+			Tenant:     result.TenantId,
+		})
 	})
 }
+
+// MakeRevokeHandler handles form with token and token_type_hint as described
+// in RFC 7009. Not valid or unknown token is not an error.
+func MakeRevokeHandler(log *logger.Logger, service *server.Server) http.HandlerFunc {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		token := r.PostFormValue("token")
+		if token == "" {
+			writeJSON(log, w, http.StatusBadRequest, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: server.ERROR_ProvideToken,
+			})
+			return
+		}
+
+		err := service.Revoke(r.Context(), r.PostFormValue(PARAM_Tenant), token, r.PostFormValue("token_type_hint"))
+		if errors.Is(err, server.ErrUnknownTenant) {
+			writeJSON(log, w, http.StatusBadRequest, ErrorResponse{
+				Error:            ERROR_InvalidRequest,
+				ErrorDescription: err.Error(),
+			})
+			return
+		}
+		if err != nil {
+			log.Error(err)
+			http.Error(w, http.StatusText(http.StatusServiceUnavailable), http.StatusServiceUnavailable)
+			return
+		}
+
+		w.WriteHeader(http.StatusOK)
+	})
+}
+
+func writeJSON(log *logger.Logger, w http.ResponseWriter, status int, body any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	err := json.NewEncoder(w).Encode(body)
+	if err != nil {
+		log.Error(err)
+	}
+}
@@ -0,0 +1,147 @@
+package http_transport
+
+import (
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/Moranilt/jwt-http2/config"
+	"github.com/Moranilt/jwt-http2/logger"
+)
+
+const (
+	testConsulKey  = "auth/v1.0.0/config.yaml"
+	testWatchToken = "secret"
+)
+
+func TestWatchHandler(t *testing.T) {
+	log := logger.New()
+	log.Out = io.Discard
+	cfg := config.New(log)
+	handler := RequireToken(log, testWatchToken, MakeWatchHandler(log, cfg, testConsulKey))
+
+	body := func(yaml string) string {
+		b, _ := json.Marshal([]config.WatchConsulBody{
+			{Key: testConsulKey, Value: base64.StdEncoding.EncodeToString([]byte(yaml))},
+		})
+		return string(b)
+	}
+	valid := body("issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 15m\n  refresh: 1d\n")
+
+	tests := []struct {
+		name   string
+		auth   string
+		body   string
+		status int
+	}{
+		{name: "without token", body: valid, status: http.StatusUnauthorized},
+		{name: "not valid token", auth: "Bearer other", body: valid, status: http.StatusUnauthorized},
+		{name: "not bearer token", auth: testWatchToken, body: valid, status: http.StatusUnauthorized},
+		{name: "not valid json", auth: "Bearer " + testWatchToken, body: "{", status: http.StatusBadRequest},
+		{name: "too large body", auth: "Bearer " + testWatchToken, body: `"` + strings.Repeat("a", WATCH_MaxBodySize) + `"`, status: http.StatusRequestEntityTooLarge},
+		{name: "not valid config", auth: "Bearer " + testWatchToken, body: body("ttl:\n  access: 15x\n  refresh: 1d\n"), status: http.StatusUnprocessableEntity},
+		{name: "config without ttl", auth: "Bearer " + testWatchToken, body: body("issuer: auth\n"), status: http.StatusUnprocessableEntity},
+		{name: "valid config", auth: "Bearer " + testWatchToken, body: valid, status: http.StatusOK},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			req := httptest.NewRequest(http.MethodPost, "/watch", strings.NewReader(test.body))
+			if test.auth != "" {
+				req.Header.Set("Authorization", test.auth)
+			}
+			w := httptest.NewRecorder()
+
+			handler(w, req)
+
+			if w.Code != test.status {
+				t.Errorf("not valid status %d, expected %d: %s", w.Code, test.status, w.Body)
+			}
+		})
+	}
+
+	if cfg.Current() == nil || cfg.Current().TTL.Access != 15*time.Minute {
+		t.Errorf("valid config was not applied: %+v", cfg.Current())
+	}
+}
+
+func TestRequireTokenWithoutToken(t *testing.T) {
+	log := logger.New()
+	log.Out = io.Discard
+	handler := RequireToken(log, "", func(w http.ResponseWriter, r *http.Request) {})
+
+	req := httptest.NewRequest(http.MethodPost, "/watch", nil)
+	req.Header.Set("Authorization", "Bearer ")
+	w := httptest.NewRecorder()
+	handler(w, req)
+
+	if w.Code != http.StatusUnauthorized {
+		t.Errorf("not valid status %d, expected %d", w.Code, http.StatusUnauthorized)
+	}
+}
+
+func TestConfigHandlers(t *testing.T) {
+	log := logger.New()
+	log.Out = io.Discard
+	cfg := config.New(log)
+	valid := "issuer: auth\nsubject: user\naudience: [http://localhost:8080]\nttl:\n  access: 15m\n  refresh: 1d\n"
+	err := cfg.WatchConsul(context.Background(), testConsulKey, []config.WatchConsulBody{
+		{Key: testConsulKey, Value: base64.StdEncoding.EncodeToString([]byte(valid))},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	validate := MakeValidateConfigHandler(log, cfg)
+
+	tests := []struct {
+		name   string
+		body   string
+		status int
+		errors int
+	}{
+		{name: "valid config", body: strings.Replace(valid, "15m", "5m", 1), status: http.StatusOK},
+		{name: "not valid config", body: "issuer: auth\nttl:\n  access: 1d\n  refresh: 15m\n", status: http.StatusUnprocessableEntity, errors: 3},
+		{name: "not valid yaml", body: "ttl: [", status: http.StatusUnprocessableEntity, errors: 1},
+		{name: "too large body", body: strings.Repeat("a", CONFIG_MaxBodySize+1), status: http.StatusRequestEntityTooLarge},
+	}
+
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			w := httptest.NewRecorder()
+			validate(w, httptest.NewRequest(http.MethodPost, "/config/validate", strings.NewReader(test.body)))
+			if w.Code != test.status {
+				t.Fatalf("not valid status %d, expected %d: %s", w.Code, test.status, w.Body)
+			}
+			if test.status == http.StatusRequestEntityTooLarge {
+				return
+			}
+
+			var response ValidateConfigResponse
+			err := json.NewDecoder(w.Body).Decode(&response)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if response.Valid != (test.status == http.StatusOK) || len(response.Errors) != test.errors {
+				t.Errorf("not valid response %+v", response)
+			}
+		})
+	}
+
+	// dry run doesn't change active config
+	w := httptest.NewRecorder()
+	MakeConfigHandler(log, cfg)(w, httptest.NewRequest(http.MethodGet, "/config", nil))
+	var response ConfigResponse
+	err = json.NewDecoder(w.Body).Decode(&response)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if response.TTL.Access != "15m0s" || response.Version == nil || response.Version.Source != "consul:"+testConsulKey {
+		t.Errorf("not valid config %+v", response)
+	}
+}
@@ -31,6 +31,101 @@ var errorTests = []struct {
 		value:       "",
 		expectedErr: ERROR_NotValidTime,
 	},
+	{
+		name:        "negative value",
+		value:       "-5m",
+		expectedErr: fmt.Sprintf(ERROR_NegativeTime, "-5m"),
+	},
+	{
+		name:        "zero compound value",
+		value:       "0h0m",
+		expectedErr: fmt.Sprintf(ERROR_NotValidQuantity, "0"),
+	},
+	{
+		name:        "number without unit",
+		value:       "15",
+		expectedErr: fmt.Sprintf(ERROR_MissingUnit, "15"),
+	},
+	{
+		name:        "compound value without last unit",
+		value:       "1h30",
+		expectedErr: fmt.Sprintf(ERROR_MissingUnit, "1h30"),
+	},
+	{
+		name:        "not valid unit in compound value",
+		value:       "1h30x",
+		expectedErr: fmt.Sprintf(ERROR_NotValidUnit, "x"),
+	},
+	{
+		name:        "only dot",
+		value:       ".s",
+		expectedErr: fmt.Sprintf(ERROR_NotValidNumberValue, "."),
+	},
+	{
+		name:        "overflow",
+		value:       "106752d",
+		expectedErr: fmt.Sprintf(ERROR_TimeOverflow, "106752d", time.Duration(1<<63-1)),
+	},
+	{
+		name:        "overflow of number",
+		value:       "99999999999999999999s",
+		expectedErr: fmt.Sprintf(ERROR_TimeOverflow, "99999999999999999999s", time.Duration(1<<63-1)),
+	},
+	{
+		name:        "overflow of sum",
+		value:       "2562047h2562047h",
+		expectedErr: fmt.Sprintf(ERROR_TimeOverflow, "2562047h2562047h", time.Duration(1<<63-1)),
+	},
+	{
+		name:        "ISO-8601 years",
+		value:       "P1Y",
+		expectedErr: fmt.Sprintf(ERROR_ISOYearsMonths, "P1Y"),
+	},
+	{
+		name:        "ISO-8601 months",
+		value:       "P1M",
+		expectedErr: fmt.Sprintf(ERROR_ISOYearsMonths, "P1M"),
+	},
+	{
+		name:        "ISO-8601 hours without T",
+		value:       "P1H",
+		expectedErr: fmt.Sprintf(ERROR_NotValidISOUnit, "H"),
+	},
+	{
+		name:        "ISO-8601 without value",
+		value:       "PT",
+		expectedErr: ERROR_NotValidTime,
+	},
+	{
+		name:        "ISO-8601 without unit",
+		value:       "PT15",
+		expectedErr: fmt.Sprintf(ERROR_MissingUnit, "PT15"),
+	},
+	{
+		name:        "ISO-8601 empty time part",
+		value:       "P1DT",
+		expectedErr: fmt.Sprintf(ERROR_ISOEmptyTime, "P1DT"),
+	},
+	{
+		name:        "ISO-8601 units out of order",
+		value:       "PT1S1H",
+		expectedErr: fmt.Sprintf(ERROR_ISOUnitOrder, "PT1S1H"),
+	},
+	{
+		name:        "ISO-8601 days before weeks",
+		value:       "P1D1W",
+		expectedErr: fmt.Sprintf(ERROR_ISOUnitOrder, "P1D1W"),
+	},
+	{
+		name:        "ISO-8601 repeated unit",
+		value:       "P1D2D",
+		expectedErr: fmt.Sprintf(ERROR_ISOUnitOrder, "P1D2D"),
+	},
+	{
+		name:        "ISO-8601 repeated time unit",
+		value:       "PT1M1M",
+		expectedErr: fmt.Sprintf(ERROR_ISOUnitOrder, "PT1M1M"),
+	},
 }
 
 var timeTests = []struct {
@@ -58,6 +153,71 @@ var timeTests = []struct {
 		value:    "7d",
 		expected: time.Duration(7) * 24 * time.Hour,
 	},
+	{
+		name:     "parsed weeks",
+		value:    "2w",
+		expected: 14 * 24 * time.Hour,
+	},
+	{
+		name:     "parsed milliseconds",
+		value:    "250ms",
+		expected: 250 * time.Millisecond,
+	},
+	{
+		name:     "parsed seconds over minute",
+		value:    "90s",
+		expected: 90 * time.Second,
+	},
+	{
+		name:     "parsed compound units",
+		value:    "1h30m",
+		expected: time.Hour + 30*time.Minute,
+	},
+	{
+		name:     "parsed compound days",
+		value:    "1w2d12h",
+		expected: 9*24*time.Hour + 12*time.Hour,
+	},
+	{
+		name:     "parsed fraction",
+		value:    "1.5h",
+		expected: 90 * time.Minute,
+	},
+	{
+		name:     "parsed Go duration",
+		value:    "1m0.5s",
+		expected: time.Minute + 500*time.Millisecond,
+	},
+	{
+		name:     "parsed microseconds",
+		value:    "10µs5us",
+		expected: 15 * time.Microsecond,
+	},
+	{
+		name:     "parsed value with plus",
+		value:    "+15m",
+		expected: 15 * time.Minute,
+	},
+	{
+		name:     "parsed ISO-8601 minutes",
+		value:    "PT15M",
+		expected: 15 * time.Minute,
+	},
+	{
+		name:     "parsed ISO-8601 days and hours",
+		value:    "P1DT12H",
+		expected: 36 * time.Hour,
+	},
+	{
+		name:     "parsed ISO-8601 weeks",
+		value:    "P2W",
+		expected: 14 * 24 * time.Hour,
+	},
+	{
+		name:     "parsed ISO-8601 fraction of seconds",
+		value:    "PT1M1.5S",
+		expected: time.Minute + 1500*time.Millisecond,
+	},
 }
 
 func TestValidateStringTime(t *testing.T) {
@@ -82,3 +242,45 @@ func TestValidateStringTime(t *testing.T) {
 		})
 	}
 }
+
+func FuzzMakeTimeFromString(f *testing.F) {
+	for _, test := range timeTests {
+		f.Add(test.value)
+	}
+	for _, test := range errorTests {
+		f.Add(test.value)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		parsed, err := MakeTimeFromString(value)
+		if err != nil {
+			return
+		}
+		if parsed <= 0 {
+			t.Errorf("not valid time %s of %q, expected time greater than 0", parsed, value)
+		}
+	})
+}
+
+// FuzzMakeTimeFromStringGoSyntax checks that positive durations of
+// time.ParseDuration are parsed to the same value.
+func FuzzMakeTimeFromStringGoSyntax(f *testing.F) {
+	for _, seed := range []string{"1h30m", "1.5h", "300ms", "2h45m30.5s", "1us", "0.000000001s", "9223372036854775807ns", ".5m"} {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, value string) {
+		expected, err := time.ParseDuration(value)
+		if err != nil || expected <= 0 {
+			return
+		}
+
+		parsed, err := MakeTimeFromString(value)
+		if err != nil {
+			t.Fatalf("not expected error for %q: %v", value, err)
+		}
+		if parsed != expected {
+			t.Errorf("not valid time %s of %q, expected %s", parsed, value, expected)
+		}
+	})
+}
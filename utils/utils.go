@@ -2,43 +2,257 @@ package utils
 
 import (
 	"fmt"
-	"strconv"
+	"strings"
 	"time"
+	"unicode/utf8"
 )
 
 const (
-	ERROR_NotValidUnit        = "not valid unit %q. Expected d, h, m, s"
+	ERROR_NotValidUnit        = "not valid unit %q. Expected w, d, h, m, s, ms, us, ns"
 	ERROR_NotValidNumberValue = "not valid number value %q"
 	ERROR_NotValidQuantity    = "not valid quantity of time. Got %q, Expected number greater than 0"
 	ERROR_NotValidTime        = "not valid time value"
+	ERROR_MissingUnit         = "missing unit in %q"
+	ERROR_NegativeTime        = "negative time %q is not allowed"
+	ERROR_TimeOverflow        = "time %q is too long. Max is %s"
+	ERROR_NotValidISOUnit     = "not valid ISO-8601 unit %q. Expected W, D, H, M, S"
+	ERROR_ISOYearsMonths      = "years and months of ISO-8601 time %q are not supported, their length is not fixed"
+	ERROR_ISOUnitOrder        = "not valid order of ISO-8601 units in %q. Expected W, D, then T and H, M, S, every unit once"
+	ERROR_ISOEmptyTime        = "empty time part after T in ISO-8601 time %q"
 )
 
+var units = map[string]uint64{
+	"ns": uint64(time.Nanosecond),
+	"us": uint64(time.Microsecond),
+	"µs": uint64(time.Microsecond), // U+00B5 micro sign
+	"μs": uint64(time.Microsecond), // U+03BC Greek letter mu
+	"ms": uint64(time.Millisecond),
+	"s":  uint64(time.Second),
+	"m":  uint64(time.Minute),
+	"h":  uint64(time.Hour),
+	"d":  uint64(24 * time.Hour),
+	"w":  uint64(7 * 24 * time.Hour),
+}
+
+var isoDateUnits = map[byte]uint64{
+	'W': uint64(7 * 24 * time.Hour),
+	'D': uint64(24 * time.Hour),
+}
+
+var isoTimeUnits = map[byte]uint64{
+	'H': uint64(time.Hour),
+	'M': uint64(time.Minute),
+	'S': uint64(time.Second),
+}
+
+// order of ISO-8601 units in date and time parts
+const (
+	isoDateOrder = "WD"
+	isoTimeOrder = "HMS"
+)
+
+// MakeTimeFromString parses positive time. Supported formats:
+//   - number with unit: 20s, 25m, 7d, 2w, 500ms
+//   - compound units and fractions as in time.ParseDuration: 1h30m, 1.5h, 1d12h
+//   - ISO-8601 duration: PT15M, P1DT12H, P2W
+//
+// Units are w(7 days), d(24 hours), h, m, s, ms, us and ns.
 func MakeTimeFromString(st string) (time.Duration, error) {
-	if len(st) < 2 {
+	st = strings.TrimSpace(st)
+	if st == "" {
 		return time.Nanosecond, fmt.Errorf(ERROR_NotValidTime)
 	}
+	if st[0] == '-' {
+		return time.Nanosecond, fmt.Errorf(ERROR_NegativeTime, st)
+	}
 
-	start, end := st[:len(st)-1], st[len(st)-1:]
-
-	startInt, err := strconv.Atoi(start)
+	var total uint64
+	var first string
+	var err error
+	if st[0] == 'P' {
+		total, first, err = parseISO(st)
+	} else {
+		total, first, err = parseUnits(strings.TrimPrefix(st, "+"), st)
+	}
 	if err != nil {
-		return time.Nanosecond, fmt.Errorf(ERROR_NotValidNumberValue, start)
+		return time.Nanosecond, err
+	}
+
+	if total == 0 {
+		return time.Nanosecond, fmt.Errorf(ERROR_NotValidQuantity, first)
+	}
+
+	return time.Duration(total), nil
+}
+
+// parseUnits parses sequence of numbers with units. First number is returned
+// to describe zero time.
+func parseUnits(s, original string) (uint64, string, error) {
+	var total uint64
+	var first string
+	for s != "" {
+		n, rest, err := parseNumber(s)
+		if err != nil {
+			return 0, "", err
+		}
+		if first == "" {
+			first = s[:len(s)-len(rest)]
+		}
+
+		i := 0
+		for i < len(rest) && rest[i] != '.' && (rest[i] < '0' || rest[i] > '9') {
+			i++
+		}
+		unit := rest[:i]
+		if unit == "" {
+			return 0, "", fmt.Errorf(ERROR_MissingUnit, original)
+		}
+		scale, ok := units[unit]
+		if !ok {
+			return 0, "", fmt.Errorf(ERROR_NotValidUnit, unit)
+		}
+
+		total, ok = n.add(total, scale)
+		if !ok {
+			return 0, "", fmt.Errorf(ERROR_TimeOverflow, original, time.Duration(1<<63-1))
+		}
+		s = rest[i:]
+	}
+
+	return total, first, nil
+}
+
+// parseISO parses ISO-8601 duration PnWnDTnHnMnS. Units follow in this
+// order and every unit is used once. Years and months are rejected.
+func parseISO(st string) (uint64, string, error) {
+	s := st[1:]
+	var total uint64
+	var first string
+	inTime := false
+	// next is an index of the first unit allowed in order of current part
+	next, timeUnits := 0, 0
+	for s != "" {
+		if s[0] == 'T' && !inTime {
+			inTime = true
+			next = 0
+			s = s[1:]
+			continue
+		}
+
+		n, rest, err := parseNumber(s)
+		if err != nil {
+			return 0, "", err
+		}
+		if first == "" {
+			first = s[:len(s)-len(rest)]
+		}
+		if rest == "" {
+			return 0, "", fmt.Errorf(ERROR_MissingUnit, st)
+		}
+
+		unit := rest[0]
+		scale, ok := isoDateUnits[unit]
+		if inTime {
+			scale, ok = isoTimeUnits[unit]
+		}
+		if !ok {
+			if !inTime && (unit == 'Y' || unit == 'M') {
+				return 0, "", fmt.Errorf(ERROR_ISOYearsMonths, st)
+			}
+			r, _ := utf8.DecodeRuneInString(rest)
+			return 0, "", fmt.Errorf(ERROR_NotValidISOUnit, string(r))
+		}
+
+		order := isoDateOrder
+		if inTime {
+			order = isoTimeOrder
+			timeUnits++
+		}
+		pos := strings.IndexByte(order, unit)
+		if pos < next {
+			return 0, "", fmt.Errorf(ERROR_ISOUnitOrder, st)
+		}
+		next = pos + 1
+
+		total, ok = n.add(total, scale)
+		if !ok {
+			return 0, "", fmt.Errorf(ERROR_TimeOverflow, st, time.Duration(1<<63-1))
+		}
+		s = rest[1:]
+	}
+
+	if inTime && timeUnits == 0 && first != "" {
+		return 0, "", fmt.Errorf(ERROR_ISOEmptyTime, st)
+	}
+	if first == "" {
+		return 0, "", fmt.Errorf(ERROR_NotValidTime)
+	}
+
+	return total, first, nil
+}
+
+// number is a decimal number with integer part and fraction/scale.
+type number struct {
+	whole    uint64
+	fraction uint64
+	scale    float64
+	overflow bool
+}
+
+// parseNumber parses leading decimal number of s like time.ParseDuration.
+func parseNumber(s string) (number, string, error) {
+	n := number{scale: 1}
+	i := 0
+	for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+		if n.whole > (1<<63-1)/10 {
+			n.overflow = true
+			continue
+		}
+		n.whole = n.whole*10 + uint64(s[i]-'0')
+		if n.whole > 1<<63-1 {
+			n.overflow = true
+		}
+	}
+	digits := i
+
+	if i < len(s) && s[i] == '.' {
+		i++
+		for ; i < len(s) && s[i] >= '0' && s[i] <= '9'; i++ {
+			digits++
+			// digits beyond precision of int64 are ignored
+			if n.fraction > (1<<63-1)/10 {
+				continue
+			}
+			n.fraction = n.fraction*10 + uint64(s[i]-'0')
+			n.scale *= 10
+		}
 	}
 
-	if startInt == 0 {
-		return time.Nanosecond, fmt.Errorf(ERROR_NotValidQuantity, start)
+	if digits == 0 {
+		r, _ := utf8.DecodeRuneInString(s)
+		return n, "", fmt.Errorf(ERROR_NotValidNumberValue, string(r))
+	}
+
+	return n, s[i:], nil
+}
+
+// add adds number of units to total. It returns false on overflow of
+// time.Duration.
+func (n number) add(total, unit uint64) (uint64, bool) {
+	if n.overflow || n.whole > (1<<63-1)/unit {
+		return 0, false
+	}
+	v := n.whole * unit
+	if n.fraction > 0 {
+		v += uint64(float64(n.fraction) * (float64(unit) / n.scale))
+		if v > 1<<63-1 {
+			return 0, false
+		}
 	}
 
-	switch end {
-	case "d":
-		return time.Duration(startInt) * 24 * time.Hour, nil
-	case "h":
-		return time.Duration(startInt) * time.Hour, nil
-	case "m":
-		return time.Duration(startInt) * time.Minute, nil
-	case "s":
-		return time.Duration(startInt) * time.Second, nil
-	default:
-		return time.Nanosecond, fmt.Errorf(ERROR_NotValidUnit, end)
+	total += v
+	if total > 1<<63-1 {
+		return 0, false
 	}
+	return total, true
 }